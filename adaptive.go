@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	log "github.com/pion/ion-log"
+	"github.com/pion/webrtc/v3"
+)
+
+// ion-sfu video qualities from the best
+var videoQualities = []string{"high", "medium", "low"}
+
+// AdaptiveConfig thresholds of adaptive layer selection, zero values use the defaults
+type AdaptiveConfig struct {
+	// sample interval, 1s if 0
+	Interval time.Duration
+	// loss fraction of a stream to be bad, 0.05 if 0
+	DownLoss float64
+	// loss fraction of a stream to be good, 0.01 if 0
+	UpLoss float64
+	// step down after bad(loss or frozen) for this long, 2s if 0
+	DownAfter time.Duration
+	// step up after good for this long, 10s if 0
+	UpAfter time.Duration
+}
+
+func (c *AdaptiveConfig) withDefaults() AdaptiveConfig {
+	config := *c
+	if config.Interval <= 0 {
+		config.Interval = time.Second
+	}
+	if config.DownLoss <= 0 {
+		config.DownLoss = 0.05
+	}
+	if config.UpLoss <= 0 {
+		config.UpLoss = 0.01
+	}
+	if config.DownAfter <= 0 {
+		config.DownAfter = 2 * time.Second
+	}
+	if config.UpAfter <= 0 {
+		config.UpAfter = 10 * time.Second
+	}
+	return config
+}
+
+// adaptiveStream quality state of a subscribed simulcast stream
+type adaptiveStream struct {
+	lost      int64
+	received  int64
+	badSince  time.Time
+	goodSince time.Time
+}
+
+// EnableAdaptiveLayers monitor the loss and freeze of subscribed video streams, step down the simulcast layer
+// when bad and back up when recovered, replaces the previous config if enabled
+func (r *RTC) EnableAdaptiveLayers(config AdaptiveConfig) {
+	r.DisableAdaptiveLayers()
+	ctx, cancel := context.WithCancel(r.ctx)
+	r.adaptiveLock.Lock()
+	r.adaptiveStop = cancel
+	r.adaptiveLock.Unlock()
+	go r.adaptLoop(ctx, config.withDefaults())
+}
+
+// DisableAdaptiveLayers stop adaptive layer selection, the current layers are kept
+func (r *RTC) DisableAdaptiveLayers() {
+	r.adaptiveLock.Lock()
+	defer r.adaptiveLock.Unlock()
+	if r.adaptiveStop != nil {
+		r.adaptiveStop()
+		r.adaptiveStop = nil
+	}
+}
+
+func (r *RTC) adaptLoop(ctx context.Context, config AdaptiveConfig) {
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+	streams := make(map[string]*adaptiveStream)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.adapt(config, streams)
+		}
+	}
+}
+
+// adapt sample the video streams once and switch layers
+func (r *RTC) adapt(config AdaptiveConfig, streams map[string]*adaptiveStream) {
+	sub := r.GetSubTransport()
+	// no transports to sample, e.g. enabled on a client failed to create them
+	if sub == nil {
+		return
+	}
+	// video ssrcs by stream id
+	ssrcs := make(map[uint32]string)
+	for _, receiver := range sub.pc.GetReceivers() {
+		for _, t := range receiver.Tracks() {
+			if t.Kind() == webrtc.RTPCodecTypeVideo {
+				ssrcs[uint32(t.SSRC())] = t.StreamID()
+			}
+		}
+	}
+	// counted from the rtp received, the sfu keeps the ssrc of a stream when switching layers
+	lost := make(map[string]int64)
+	received := make(map[string]int64)
+	for ssrc, streamID := range ssrcs {
+		if c, ok := sub.stats.stream(ssrc); ok {
+			lost[streamID] += c.lost(true)
+			received[streamID] += int64(c.packets)
+		}
+	}
+
+	now := time.Now()
+	for streamID := range streams {
+		if _, ok := received[streamID]; !ok {
+			delete(streams, streamID)
+		}
+	}
+	for streamID := range received {
+		stream, ok := streams[streamID]
+		if !ok {
+			streams[streamID] = &adaptiveStream{lost: lost[streamID], received: received[streamID], goodSince: now}
+			continue
+		}
+		deltaLost, deltaReceived := lost[streamID]-stream.lost, received[streamID]-stream.received
+		stream.lost, stream.received = lost[streamID], received[streamID]
+		if deltaLost < 0 {
+			deltaLost = 0
+		}
+
+		// frozen if nothing received
+		bad := deltaReceived <= 0
+		good := false
+		if !bad {
+			fraction := float64(deltaLost) / float64(deltaLost+deltaReceived)
+			bad = fraction >= config.DownLoss
+			good = fraction <= config.UpLoss
+		}
+		switch {
+		case bad:
+			stream.goodSince = time.Time{}
+			if stream.badSince.IsZero() {
+				stream.badSince = now
+			}
+			if now.Sub(stream.badSince) >= config.DownAfter && r.stepLayer(streamID, 1) {
+				stream.badSince = now
+			}
+		case good:
+			stream.badSince = time.Time{}
+			if stream.goodSince.IsZero() {
+				stream.goodSince = now
+			}
+			if now.Sub(stream.goodSince) >= config.UpAfter && r.stepLayer(streamID, -1) {
+				stream.goodSince = now
+			}
+		default:
+			stream.badSince, stream.goodSince = time.Time{}, time.Time{}
+		}
+	}
+}
+
+// stepLayer move the video quality of a stream down(1) or up(-1), false if already at the end
+func (r *RTC) stepLayer(streamID string, step int) bool {
+	r.callLock.Lock()
+	call, ok := r.calls[streamID]
+	r.callLock.Unlock()
+	if !ok {
+		call = Call{StreamID: streamID, Video: videoQualities[0], Audio: true}
+	}
+	// video not selected("none") is left alone
+	current := -1
+	for i, quality := range videoQualities {
+		if call.Video == quality {
+			current = i
+		}
+	}
+	if current < 0 {
+		return false
+	}
+	next := current + step
+	if next < 0 || next >= len(videoQualities) {
+		return false
+	}
+	log.Infof("id=%v adaptive streamId=%v video %v => %v", r.uid, streamID, videoQualities[current], videoQualities[next])
+	if err := r.selectRemote(streamID, videoQualities[next], call.Audio); err != nil {
+		log.Errorf("id=%v adaptive selectRemote err=%v", r.uid, err)
+		return false
+	}
+	return true
+}
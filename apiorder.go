@@ -0,0 +1,11 @@
+package engine
+
+// SetAPIOrdered choose ordered(reliable) or unordered(unreliable) delivery of the api datachannel for layer switches.
+// ion-sfu creates the api datachannel reliable and ordered, and the ordering and retransmits of a datachannel are
+// fixed by its creator, so only ordered is supported and ErrAPIUnorderedNotSupported is returned for unordered
+func (r *RTC) SetAPIOrdered(ordered bool) error {
+	if !ordered {
+		return ErrAPIUnorderedNotSupported
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"math"
+
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+)
+
+// weight of the newest sample when smoothing audio level
+const audioLevelAlpha = 0.3
+
+// audioLevelExtensionID find the negotiated id of ssrc-audio-level extension, 0 if not found
+func audioLevelExtensionID(receiver *webrtc.RTPReceiver) uint8 {
+	for _, ext := range receiver.GetParameters().HeaderExtensions {
+		if ext.URI == sdp.AudioLevelURI {
+			return uint8(ext.ID)
+		}
+	}
+	return 0
+}
+
+// updateAudioLevel parse the audio level of a rtp packet and smooth it by stream
+func (r *RTC) updateAudioLevel(streamID string, id uint8, pkt []byte) {
+	var h rtp.Header
+	if _, err := h.Unmarshal(pkt); err != nil {
+		return
+	}
+	payload := h.GetExtension(id)
+	if payload == nil {
+		return
+	}
+	var ext rtp.AudioLevelExtension
+	if err := ext.Unmarshal(payload); err != nil {
+		return
+	}
+	// level is -dBov, 0 is the loudest, 127 is silence
+	level := math.Pow(10, -float64(ext.Level)/20)
+
+	r.levelLock.Lock()
+	defer r.levelLock.Unlock()
+	if last, ok := r.audioLevels[streamID]; ok {
+		level = last*(1-audioLevelAlpha) + level*audioLevelAlpha
+	}
+	r.audioLevels[streamID] = level
+}
+
+// GetAudioLevel return the smoothed audio level(0-1) of a remote stream, parsed from the
+// ssrc-audio-level header extension, only available with the default read loop
+func (r *RTC) GetAudioLevel(streamID string) float64 {
+	r.levelLock.Lock()
+	defer r.levelLock.Unlock()
+	return r.audioLevels[streamID]
+}
@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"math"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// e-model constants tuned for opus voice
+const (
+	// R of a perfect narrow/wide band call
+	emodelR0 = 93.2
+	// equipment impairment of opus without loss
+	opusIe = 0.0
+	// loss robustness of opus with PLC/FEC
+	opusBpl = 20.0
+	// codec + playout buffer delay in ms, not included in rtt/jitter
+	audioBaseDelay = 40.0
+)
+
+// AudioQuality estimated voice QoE of a received opus track
+type AudioQuality struct {
+	TrackID string
+	// mean opinion score 1.0~4.5
+	MOS float64
+	// e-model rating factor 0~100
+	R float64
+	// packet loss rate 0~1
+	LossRate float64
+	// jitter in seconds
+	Jitter float64
+	// round trip time of the sub transport in seconds
+	RTT float64
+	// one-way mouth to ear delay estimated in ms
+	Delay float64
+	// impairments deducted from R
+	DelayImpairment float64
+	LossImpairment  float64
+}
+
+// AudioQuality estimate the MOS of a subscribed opus track from its loss, jitter and rtt(e-model, ITU-T G.107).
+// loss and jitter are counted from the rtp received(see InboundStats), errNoStats before the first packet
+func (r *RTC) AudioQuality(trackID string) (AudioQuality, error) {
+	quality := AudioQuality{TrackID: trackID}
+	if err := r.checkJoined(); err != nil {
+		return quality, err
+	}
+	track := r.remoteTrack(trackID)
+	if track == nil || !strings.EqualFold(track.Codec().MimeType, webrtc.MimeTypeOpus) {
+		return quality, errInvalidTrack
+	}
+	stats, err := r.InboundStats(trackID)
+	if err != nil {
+		return quality, err
+	}
+	if stats.Packets == 0 {
+		return quality, errNoStats
+	}
+
+	if total := float64(stats.Packets) + float64(stats.Lost); total > 0 && stats.Lost > 0 {
+		quality.LossRate = float64(stats.Lost) / total
+	}
+	quality.Jitter = stats.Jitter
+	quality.RTT = r.subRTT()
+
+	// one way network delay + jitter buffer(2x jitter) + codec
+	quality.Delay = quality.RTT*1000/2 + quality.Jitter*1000*2 + audioBaseDelay
+	quality.DelayImpairment = 0.024 * quality.Delay
+	if quality.Delay > 177.3 {
+		quality.DelayImpairment += 0.11 * (quality.Delay - 177.3)
+	}
+	loss := quality.LossRate * 100
+	quality.LossImpairment = opusIe + (95-opusIe)*loss/(loss+opusBpl)
+
+	quality.R = math.Max(0, math.Min(100, emodelR0-quality.DelayImpairment-quality.LossImpairment))
+	quality.MOS = mos(quality.R)
+	return quality, nil
+}
+
+// mos convert e-model R to MOS
+func mos(r float64) float64 {
+	switch {
+	case r <= 0:
+		return 1
+	case r >= 100:
+		return 4.5
+	}
+	return math.Min(4.5, 1+0.035*r+7e-6*r*(r-60)*(100-r))
+}
+
+// remoteTrack find a subscribed track by id
+func (r *RTC) remoteTrack(trackID string) *webrtc.TrackRemote {
+	sub := r.GetSubTransport()
+	if sub == nil {
+		return nil
+	}
+	for _, receiver := range sub.pc.GetReceivers() {
+		for _, t := range receiver.Tracks() {
+			if t.ID() == trackID {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+// subRTT get the rtt of the nominated candidate pair of the sub transport in seconds
+func (r *RTC) subRTT() float64 {
+	sub := r.GetSubTransport()
+	if sub == nil {
+		return 0
+	}
+	for _, s := range sub.pc.GetStats() {
+		if s, ok := s.(webrtc.ICECandidatePairStats); ok && s.Nominated {
+			return s.CurrentRoundTripTime
+		}
+	}
+	return 0
+}
@@ -0,0 +1,41 @@
+package engine
+
+import (
+	log "github.com/pion/ion-log"
+	"github.com/pion/ion/proto/rtc"
+	"google.golang.org/protobuf/proto"
+)
+
+// auditSignaller pass the serialized signaling messages to OnSignalSend/OnSignalRecv
+type auditSignaller struct {
+	Signaller
+	rtc *RTC
+}
+
+// Send implements Signaller
+func (s *auditSignaller) Send(request *rtc.Request) error {
+	if f := s.rtc.callbacks().OnSignalSend; f != nil {
+		if data, err := proto.Marshal(request); err != nil {
+			log.Errorf("id=%v marshal request err=%v", s.rtc.uid, err)
+		} else {
+			f(data)
+		}
+	}
+	return s.Signaller.Send(request)
+}
+
+// Recv implements Signaller
+func (s *auditSignaller) Recv() (*rtc.Reply, error) {
+	reply, err := s.Signaller.Recv()
+	if err != nil {
+		return reply, err
+	}
+	if f := s.rtc.callbacks().OnSignalRecv; f != nil {
+		if data, err := proto.Marshal(reply); err != nil {
+			log.Errorf("id=%v marshal reply err=%v", s.rtc.uid, err)
+		} else {
+			f(data)
+		}
+	}
+	return reply, nil
+}
@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"sync/atomic"
+
+	log "github.com/pion/ion-log"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+)
+
+// SetMaxPublishBandwidth cap the total send bitrate by b=AS(kbps) in the pub offer, at session level and on
+// each media section, 0 removes the cap, renegotiate if already published
+func (r *RTC) SetMaxPublishBandwidth(kbps int) error {
+	pub := r.GetPubTransport()
+	if kbps < 0 {
+		return errInvalidParams
+	}
+	if old := atomic.SwapInt32(&r.maxPubKbps, int32(kbps)); old == int32(kbps) {
+		return nil
+	}
+	log.Infof("id=%v SetMaxPublishBandwidth %vkbps", r.uid, kbps)
+	if pub != nil && pub.pc.CurrentRemoteDescription() != nil {
+		r.onNegotiationNeeded()
+	}
+	return nil
+}
+
+// bandwidthSDP add b=AS of SetMaxPublishBandwidth to the pub offer
+func (r *RTC) bandwidthSDP(offer string) string {
+	kbps := uint64(atomic.LoadInt32(&r.maxPubKbps))
+	if kbps == 0 {
+		return offer
+	}
+
+	desc := &sdp.SessionDescription{}
+	if err := desc.Unmarshal([]byte(offer)); err != nil {
+		log.Errorf("id=%v bandwidthSDP err=%v", r.uid, err)
+		return offer
+	}
+	desc.Bandwidth = withBandwidthAS(desc.Bandwidth, kbps)
+	for _, m := range desc.MediaDescriptions {
+		// datachannel is not capped
+		if m.MediaName.Media == webrtc.RTPCodecTypeAudio.String() || m.MediaName.Media == webrtc.RTPCodecTypeVideo.String() {
+			m.Bandwidth = withBandwidthAS(m.Bandwidth, kbps)
+		}
+	}
+	marshalled, err := desc.Marshal()
+	if err != nil {
+		log.Errorf("id=%v bandwidthSDP err=%v", r.uid, err)
+		return offer
+	}
+	return string(marshalled)
+}
+
+// withBandwidthAS replace the b=AS line
+func withBandwidthAS(bandwidth []sdp.Bandwidth, kbps uint64) []sdp.Bandwidth {
+	result := make([]sdp.Bandwidth, 0, len(bandwidth)+1)
+	for _, b := range bandwidth {
+		if b.Type != "AS" {
+			result = append(result, b)
+		}
+	}
+	return append(result, sdp.Bandwidth{Type: "AS", Bandwidth: kbps})
+}
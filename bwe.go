@@ -0,0 +1,263 @@
+package engine
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/interceptor"
+	log "github.com/pion/ion-log"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+)
+
+// send side bandwidth estimation by transport-cc feedback, a simplified GCC:
+// delay based(queuing delay trend) + loss based, capped by the acked bitrate
+const (
+	bweInitBitrate = 300000
+	bweMinBitrate  = 30000
+	bweMaxBitrate  = 10000000
+	// window to calc acked bitrate, loss and delay trend
+	bweWindow = 500 * time.Millisecond
+	// queuing delay grown in a window to detect overuse
+	bweOveruseDelay = 10 * time.Millisecond
+	// sent packets not acked are dropped after
+	bweHistory = 5 * time.Second
+)
+
+type sentPacket struct {
+	size int
+	at   time.Time
+}
+
+// sendEstimator estimate the send bitrate of the pub transport
+type sendEstimator struct {
+	// atomic, 64-bit aligned first
+	estimate uint64
+	seq      uint32
+	// feedbacks received
+	feedbacks uint32
+
+	sent map[uint16]sentPacket
+
+	windowStart time.Time
+	ackedBytes  int
+	received    int
+	lost        int
+	// queuing delay grown in the window, arrival delta - send delta
+	delayGrowth time.Duration
+	lastSend    time.Time
+	lastArrival time.Duration
+	sync.Mutex
+}
+
+func newSendEstimator() *sendEstimator {
+	return &sendEstimator{
+		estimate:    bweInitBitrate,
+		sent:        make(map[uint16]sentPacket),
+		windowStart: time.Now(),
+	}
+}
+
+// Estimate get the estimated send bitrate in bps
+func (e *sendEstimator) Estimate() uint64 {
+	return atomic.LoadUint64(&e.estimate)
+}
+
+// onSent record a packet with transport-wide sequence number
+func (e *sendEstimator) onSent(seq uint16, size int) {
+	e.Lock()
+	defer e.Unlock()
+	e.sent[seq] = sentPacket{size: size, at: time.Now()}
+}
+
+// onFeedback update the estimate by a transport-cc feedback
+func (e *sendEstimator) onFeedback(fb *rtcp.TransportLayerCC) {
+	atomic.AddUint32(&e.feedbacks, 1)
+	e.Lock()
+	defer e.Unlock()
+
+	// arrival time of the first packet, reference time is in 64ms
+	arrival := time.Duration(fb.ReferenceTime) * 64 * time.Millisecond
+	deltas := fb.RecvDeltas
+	seq := fb.BaseSequenceNumber
+	for _, status := range tccStatuses(fb) {
+		sent, ok := e.sent[seq]
+		delete(e.sent, seq)
+		seq++
+		if status == rtcp.TypeTCCPacketNotReceived {
+			if ok {
+				e.lost++
+			}
+			continue
+		}
+		if len(deltas) == 0 {
+			break
+		}
+		arrival += time.Duration(deltas[0].Delta) * time.Microsecond
+		deltas = deltas[1:]
+		if !ok {
+			continue
+		}
+		e.received++
+		e.ackedBytes += sent.size
+		if !e.lastSend.IsZero() && sent.at.After(e.lastSend) {
+			e.delayGrowth += (arrival - e.lastArrival) - sent.at.Sub(e.lastSend)
+		}
+		e.lastSend, e.lastArrival = sent.at, arrival
+	}
+
+	now := time.Now()
+	for seq, sent := range e.sent {
+		if now.Sub(sent.at) > bweHistory {
+			delete(e.sent, seq)
+		}
+	}
+	if elapsed := now.Sub(e.windowStart); elapsed >= bweWindow {
+		e.update(elapsed)
+		e.windowStart = now
+		e.ackedBytes, e.received, e.lost, e.delayGrowth = 0, 0, 0, 0
+	}
+}
+
+// update the estimate at the end of a window
+func (e *sendEstimator) update(elapsed time.Duration) {
+	estimate := float64(atomic.LoadUint64(&e.estimate))
+	acked := float64(e.ackedBytes*8) / elapsed.Seconds()
+	loss := 0.0
+	if total := e.received + e.lost; total > 0 {
+		loss = float64(e.lost) / float64(total)
+	}
+
+	switch {
+	case e.delayGrowth > bweOveruseDelay:
+		// queue building, back off below what got through
+		estimate = 0.85 * acked
+	case loss > 0.1:
+		estimate *= 1 - 0.5*loss
+	case loss < 0.02:
+		estimate *= 1.08
+	}
+	// can not be much higher than what is acked, unless sending little
+	if limit := 1.5 * acked; acked > bweMinBitrate && estimate > limit {
+		estimate = limit
+	}
+	if estimate < bweMinBitrate {
+		estimate = bweMinBitrate
+	}
+	if estimate > bweMaxBitrate {
+		estimate = bweMaxBitrate
+	}
+	atomic.StoreUint64(&e.estimate, uint64(estimate))
+	log.Debugf("bwe estimate=%.0f acked=%.0f loss=%.3f delay growth=%v", estimate, acked, loss, e.delayGrowth)
+}
+
+// tccStatuses expand the packet status chunks of a feedback
+func tccStatuses(fb *rtcp.TransportLayerCC) []uint16 {
+	statuses := make([]uint16, 0, fb.PacketStatusCount)
+	for _, chunk := range fb.PacketChunks {
+		switch chunk := chunk.(type) {
+		case *rtcp.RunLengthChunk:
+			for i := uint16(0); i < chunk.RunLength; i++ {
+				statuses = append(statuses, chunk.PacketStatusSymbol)
+			}
+		case *rtcp.StatusVectorChunk:
+			statuses = append(statuses, chunk.SymbolList...)
+		}
+	}
+	if len(statuses) > int(fb.PacketStatusCount) {
+		statuses = statuses[:fb.PacketStatusCount]
+	}
+	return statuses
+}
+
+// bweInterceptorFactory build bweInterceptor for the pub transport
+type bweInterceptorFactory struct {
+	bwe *sendEstimator
+}
+
+// NewInterceptor implements interceptor.Factory
+func (f *bweInterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &bweInterceptor{bwe: f.bwe}, nil
+}
+
+// bweInterceptor write transport-wide sequence numbers, and feed transport-cc feedbacks to the estimator
+type bweInterceptor struct {
+	interceptor.NoOp
+	bwe *sendEstimator
+}
+
+// BindRTCPReader implements interceptor.Interceptor
+func (i *bweInterceptor) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err != nil {
+			return n, attr, err
+		}
+		pkts, err := rtcp.Unmarshal(b[:n])
+		if err != nil {
+			return n, attr, nil
+		}
+		for _, pkt := range pkts {
+			if fb, ok := pkt.(*rtcp.TransportLayerCC); ok {
+				i.bwe.onFeedback(fb)
+			}
+		}
+		return n, attr, nil
+	})
+}
+
+// BindLocalStream implements interceptor.Interceptor
+func (i *bweInterceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	var id uint8
+	for _, ext := range info.RTPHeaderExtensions {
+		if ext.URI == sdp.TransportCCURI {
+			id = uint8(ext.ID)
+		}
+	}
+	if id == 0 {
+		return writer
+	}
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		seq := uint16(atomic.AddUint32(&i.bwe.seq, 1) - 1)
+		ext, err := (&rtp.TransportCCExtension{TransportSequence: seq}).Marshal()
+		if err != nil {
+			return 0, err
+		}
+		if err = header.SetExtension(id, ext); err != nil {
+			return 0, err
+		}
+		i.bwe.onSent(seq, header.MarshalSize()+len(payload))
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+// EstimatedSendBitrate get the send bitrate estimated by transport-cc feedback of sfu in bps,
+// e.g. to drive the encoder bitrate, the initial estimate is returned before any feedback
+func (r *RTC) EstimatedSendBitrate() uint64 {
+	pub := r.GetPubTransport()
+	if pub == nil || pub.bwe == nil {
+		return 0
+	}
+	return pub.bwe.Estimate()
+}
+
+// congestionBitrate get the estimated send bitrate, 0 before any feedback, e.g. the sfu doesn't send transport-cc
+func (r *RTC) congestionBitrate() uint64 {
+	pub := r.GetPubTransport()
+	if pub == nil || pub.bwe == nil || atomic.LoadUint32(&pub.bwe.feedbacks) == 0 {
+		return 0
+	}
+	return pub.bwe.Estimate()
+}
+
+// readSenderRTCP read rtcp of a sender until it stops, the interceptors(bwe, stats) only see rtcp read
+func readSenderRTCP(sender *webrtc.RTPSender) {
+	for {
+		if _, _, err := sender.ReadRTCP(); err != nil {
+			return
+		}
+	}
+}
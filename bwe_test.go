@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+)
+
+// feedback build a transport-cc feedback of statuses from base, received packets arrive every delta
+func feedback(base uint16, statuses []uint16, delta time.Duration) *rtcp.TransportLayerCC {
+	fb := &rtcp.TransportLayerCC{
+		BaseSequenceNumber: base,
+		PacketStatusCount:  uint16(len(statuses)),
+		PacketChunks: []rtcp.PacketStatusChunk{
+			&rtcp.StatusVectorChunk{SymbolSize: rtcp.TypeTCCSymbolSizeTwoBit, SymbolList: statuses},
+		},
+	}
+	for _, status := range statuses {
+		if status != rtcp.TypeTCCPacketNotReceived {
+			fb.RecvDeltas = append(fb.RecvDeltas, &rtcp.RecvDelta{Type: status, Delta: delta.Microseconds()})
+		}
+	}
+	return fb
+}
+
+func TestTCCStatuses(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		fb   *rtcp.TransportLayerCC
+		want int
+	}{
+		{
+			name: "run length",
+			fb: &rtcp.TransportLayerCC{PacketStatusCount: 5, PacketChunks: []rtcp.PacketStatusChunk{
+				&rtcp.RunLengthChunk{PacketStatusSymbol: rtcp.TypeTCCPacketReceivedSmallDelta, RunLength: 5},
+			}},
+			want: 5,
+		},
+		{
+			name: "vector padded",
+			fb: &rtcp.TransportLayerCC{PacketStatusCount: 3, PacketChunks: []rtcp.PacketStatusChunk{
+				&rtcp.StatusVectorChunk{SymbolList: []uint16{1, 0, 1, 0, 0, 0, 0}},
+			}},
+			want: 3,
+		},
+		{
+			name: "mixed",
+			fb: &rtcp.TransportLayerCC{PacketStatusCount: 9, PacketChunks: []rtcp.PacketStatusChunk{
+				&rtcp.RunLengthChunk{PacketStatusSymbol: rtcp.TypeTCCPacketNotReceived, RunLength: 2},
+				&rtcp.StatusVectorChunk{SymbolList: []uint16{1, 1, 1, 1, 1, 1, 1}},
+			}},
+			want: 9,
+		},
+	} {
+		if got := len(tccStatuses(c.fb)); got != c.want {
+			t.Errorf("%v: got %v statuses, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSendEstimator(t *testing.T) {
+	const packets = 20
+	received := make([]uint16, packets)
+	halfLost := make([]uint16, packets)
+	for i := range received {
+		received[i] = rtcp.TypeTCCPacketReceivedSmallDelta
+		halfLost[i] = rtcp.TypeTCCPacketReceivedSmallDelta
+		if i%2 == 1 {
+			halfLost[i] = rtcp.TypeTCCPacketNotReceived
+		}
+	}
+	for _, c := range []struct {
+		name     string
+		statuses []uint16
+		size     int
+		// sent every 10ms, arrive every delta
+		delta time.Duration
+		check func(estimate uint64) bool
+	}{
+		{"no loss", received, 1200, 10 * time.Millisecond, func(e uint64) bool { return e > bweInitBitrate }},
+		{"loss", halfLost, 1200, 10 * time.Millisecond, func(e uint64) bool { return e < bweInitBitrate }},
+		// backs off below the 192kbps acked
+		{"queuing", received, 600, 20 * time.Millisecond, func(e uint64) bool { return e < 192000 }},
+	} {
+		e := newSendEstimator()
+		start := time.Now().Add(-bweWindow)
+		e.windowStart = start
+		for i := 0; i < packets; i++ {
+			e.sent[uint16(i)] = sentPacket{size: c.size, at: start.Add(time.Duration(i) * 10 * time.Millisecond)}
+		}
+		e.onFeedback(feedback(0, c.statuses, c.delta))
+		if estimate := e.Estimate(); !c.check(estimate) {
+			t.Errorf("%v: estimate=%v", c.name, estimate)
+		}
+		if len(e.sent) != 0 {
+			t.Errorf("%v: %v packets not acked", c.name, len(e.sent))
+		}
+	}
+}
+
+func TestBWEInterceptorSequence(t *testing.T) {
+	const id = 3
+	for _, mime := range []string{MimeTypeOpus, MimeTypeVP8} {
+		e := newSendEstimator()
+		i := &bweInterceptor{bwe: e}
+		var seqs []uint16
+		writer := i.BindLocalStream(&interceptor.StreamInfo{
+			MimeType:            mime,
+			RTPHeaderExtensions: []interceptor.RTPHeaderExtension{{URI: sdp.TransportCCURI, ID: id}},
+		}, interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, _ interceptor.Attributes) (int, error) {
+			ext := &rtp.TransportCCExtension{}
+			if err := ext.Unmarshal(header.GetExtension(id)); err != nil {
+				t.Fatal(err)
+			}
+			seqs = append(seqs, ext.TransportSequence)
+			return len(payload), nil
+		}))
+		for n := 0; n < 3; n++ {
+			if _, err := writer.Write(&rtp.Header{}, make([]byte, 100), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(seqs) != 3 || seqs[0] != 0 || seqs[2] != 2 {
+			t.Errorf("%v: transport sequence numbers %v", mime, seqs)
+		}
+		if len(e.sent) != 3 {
+			t.Errorf("%v: %v packets recorded", mime, len(e.sent))
+		}
+	}
+}
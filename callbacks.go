@@ -0,0 +1,200 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Callbacks of RTC, they are read by goroutines started in Join, set them before Join,
+// or by the setters below to swap handlers safely mid-session
+type Callbacks struct {
+	OnTrack       func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver)
+	OnDataChannel func(*webrtc.DataChannel)
+	// OnRemoteTrack is OnTrack with the uid of the participant from track events, empty if the track arrives
+	// before its event, not fired if OnTrack is set
+	OnRemoteTrack func(uid string, track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver)
+	OnError       func(error)
+	OnTrackEvent  func(event TrackEvent)
+	OnSpeaker     func(event []string)
+	// OnSubscriptionLimit fired when a track event is not auto subscribed because of MaxSubscriptions
+	OnSubscriptionLimit func(event TrackEvent)
+	// OnAPIReady fired when the ion-sfu api datachannel is open
+	OnAPIReady func()
+	// OnLocalSSRCChange fired when a published track's sender ssrc is (re)assigned
+	OnLocalSSRCChange func(trackID string, ssrc uint32)
+	// OnDataChannelOpen/OnDataChannelClose fired on state changes of any custom datachannel,
+	// setting dc.OnOpen/dc.OnClose on a channel replaces them for that channel
+	OnDataChannelOpen  func(label string)
+	OnDataChannelClose func(label string)
+	// OnVideoFreeze fired when a subscribed video track stalls, OnVideoResume when rtp arrives again,
+	// duration is how long the track has been frozen
+	OnVideoFreeze func(trackID string, duration time.Duration)
+	OnVideoResume func(trackID string, duration time.Duration)
+	// OnPublishEnded fired for each track of the publishing file when it ends, if NoFileLoop
+	OnPublishEnded func(trackID string)
+	// OnStats fired with the stats of both transports every StatsInterval until Close, the pinned pion has no rtp
+	// stream stats in them, see OutboundStats/InboundStats/SessionStats
+	OnStats func(pub, sub webrtc.StatsReport)
+	// OnPacketLoss fired with the sub loss fraction(counted from the rtp received) when it rises above
+	// PacketLossThreshold, and when it drops back
+	OnPacketLoss func(fraction float64)
+	// OnEncrypt/OnDecrypt transform the rtp payloads of all tracks before sending and after receiving, for end-to-end
+	// encryption independent of DTLS, rtp headers stay in clear. Leave the codec payload header parsed by sfu in clear
+	// (e.g. the VP8 payload descriptor for simulcast and keyframes), set before Join
+	OnEncrypt func(payload []byte) []byte
+	OnDecrypt func(payload []byte) []byte
+	// OnTrackEnded fired when a track read by the default read loop(OnTrack not set) ends
+	OnTrackEnded func(trackID string)
+	// OnSubscribeTimeout fired when no rtp of a subscribed track arrives in SubscribeTimeout, e.g. the publisher crashed
+	OnSubscribeTimeout func(trackID string)
+	// OnSignalSend/OnSignalRecv get every signaling message serialized in protobuf(the grpc wire format),
+	// for audit logging or replay
+	OnSignalSend func(data []byte)
+	OnSignalRecv func(data []byte)
+	// OnClose fired once when the client is closed, reason is nil if by Close, or why it is closed internally
+	OnClose func(reason error)
+	// OnStateChange fired when the lifecycle state changes, see State
+	OnStateChange func(state ClientState)
+}
+
+// setCallback change the callbacks under callbackLock, all the setters go through it
+func (r *RTC) setCallback(set func(c *Callbacks)) {
+	r.callbackLock.Lock()
+	defer r.callbackLock.Unlock()
+	set(&r.Callbacks)
+}
+
+// callbacks get a snapshot of the callbacks under callbackLock, read them by it only
+func (r *RTC) callbacks() Callbacks {
+	r.callbackLock.RLock()
+	defer r.callbackLock.RUnlock()
+	return r.Callbacks
+}
+
+// SetOnTrack set OnTrack, nil reads tracks by the default read loop
+func (r *RTC) SetOnTrack(f func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver)) {
+	r.setCallback(func(c *Callbacks) { c.OnTrack = f })
+}
+
+// SetOnDataChannel set OnDataChannel
+func (r *RTC) SetOnDataChannel(f func(*webrtc.DataChannel)) {
+	r.setCallback(func(c *Callbacks) { c.OnDataChannel = f })
+}
+
+// SetOnRemoteTrack set OnRemoteTrack
+func (r *RTC) SetOnRemoteTrack(f func(uid string, track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver)) {
+	r.setCallback(func(c *Callbacks) { c.OnRemoteTrack = f })
+}
+
+// SetOnError set OnError
+func (r *RTC) SetOnError(f func(error)) {
+	r.setCallback(func(c *Callbacks) { c.OnError = f })
+}
+
+// SetOnTrackEvent set OnTrackEvent, nil subscribes tracks automatically
+func (r *RTC) SetOnTrackEvent(f func(event TrackEvent)) {
+	r.setCallback(func(c *Callbacks) { c.OnTrackEvent = f })
+}
+
+// SetOnSpeaker set OnSpeaker
+func (r *RTC) SetOnSpeaker(f func(event []string)) {
+	r.setCallback(func(c *Callbacks) { c.OnSpeaker = f })
+}
+
+// SetOnSubscriptionLimit set OnSubscriptionLimit
+func (r *RTC) SetOnSubscriptionLimit(f func(event TrackEvent)) {
+	r.setCallback(func(c *Callbacks) { c.OnSubscriptionLimit = f })
+}
+
+// SetOnAPIReady set OnAPIReady
+func (r *RTC) SetOnAPIReady(f func()) {
+	r.setCallback(func(c *Callbacks) { c.OnAPIReady = f })
+}
+
+// SetOnLocalSSRCChange set OnLocalSSRCChange
+func (r *RTC) SetOnLocalSSRCChange(f func(trackID string, ssrc uint32)) {
+	r.setCallback(func(c *Callbacks) { c.OnLocalSSRCChange = f })
+}
+
+// SetOnDataChannelOpen set OnDataChannelOpen
+func (r *RTC) SetOnDataChannelOpen(f func(label string)) {
+	r.setCallback(func(c *Callbacks) { c.OnDataChannelOpen = f })
+}
+
+// SetOnDataChannelClose set OnDataChannelClose
+func (r *RTC) SetOnDataChannelClose(f func(label string)) {
+	r.setCallback(func(c *Callbacks) { c.OnDataChannelClose = f })
+}
+
+// SetOnVideoFreeze set OnVideoFreeze
+func (r *RTC) SetOnVideoFreeze(f func(trackID string, duration time.Duration)) {
+	r.setCallback(func(c *Callbacks) { c.OnVideoFreeze = f })
+}
+
+// SetOnVideoResume set OnVideoResume
+func (r *RTC) SetOnVideoResume(f func(trackID string, duration time.Duration)) {
+	r.setCallback(func(c *Callbacks) { c.OnVideoResume = f })
+}
+
+// SetOnPublishEnded set OnPublishEnded
+func (r *RTC) SetOnPublishEnded(f func(trackID string)) {
+	r.setCallback(func(c *Callbacks) { c.OnPublishEnded = f })
+}
+
+// SetOnStats set OnStats
+func (r *RTC) SetOnStats(f func(pub, sub webrtc.StatsReport)) {
+	r.setCallback(func(c *Callbacks) { c.OnStats = f })
+}
+
+// SetOnPacketLoss set OnPacketLoss
+func (r *RTC) SetOnPacketLoss(f func(fraction float64)) {
+	r.setCallback(func(c *Callbacks) { c.OnPacketLoss = f })
+}
+
+// SetOnEncrypt set OnEncrypt
+func (r *RTC) SetOnEncrypt(f func(payload []byte) []byte) {
+	r.setCallback(func(c *Callbacks) { c.OnEncrypt = f })
+}
+
+// SetOnDecrypt set OnDecrypt
+func (r *RTC) SetOnDecrypt(f func(payload []byte) []byte) {
+	r.setCallback(func(c *Callbacks) { c.OnDecrypt = f })
+}
+
+// SetOnTrackEnded set OnTrackEnded
+func (r *RTC) SetOnTrackEnded(f func(trackID string)) {
+	r.setCallback(func(c *Callbacks) { c.OnTrackEnded = f })
+}
+
+// SetOnSubscribeTimeout set OnSubscribeTimeout
+func (r *RTC) SetOnSubscribeTimeout(f func(trackID string)) {
+	r.setCallback(func(c *Callbacks) { c.OnSubscribeTimeout = f })
+}
+
+// SetOnSignalSend set OnSignalSend
+func (r *RTC) SetOnSignalSend(f func(data []byte)) {
+	r.setCallback(func(c *Callbacks) { c.OnSignalSend = f })
+}
+
+// SetOnSignalRecv set OnSignalRecv
+func (r *RTC) SetOnSignalRecv(f func(data []byte)) {
+	r.setCallback(func(c *Callbacks) { c.OnSignalRecv = f })
+}
+
+// SetOnClose set OnClose
+func (r *RTC) SetOnClose(f func(reason error)) {
+	r.setCallback(func(c *Callbacks) { c.OnClose = f })
+}
+
+// SetOnStateChange set OnStateChange
+func (r *RTC) SetOnStateChange(f func(state ClientState)) {
+	r.setCallback(func(c *Callbacks) { c.OnStateChange = f })
+}
+
+// onError fire OnError if set
+func (r *RTC) onError(err error) {
+	if onError := r.callbacks().OnError; onError != nil {
+		onError(err)
+	}
+}
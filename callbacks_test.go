@@ -0,0 +1,30 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+// every callback has a setter going through callbackLock
+func TestCallbackSetters(t *testing.T) {
+	r := withConfig()
+	rtc := reflect.ValueOf(r)
+	fields := reflect.TypeOf(Callbacks{})
+	for i := 0; i < fields.NumField(); i++ {
+		field := fields.Field(i)
+		setter := rtc.MethodByName("Set" + field.Name)
+		if !setter.IsValid() {
+			t.Errorf("%v has no setter", field.Name)
+			continue
+		}
+		if setter.Type().NumIn() != 1 || setter.Type().In(0) != field.Type {
+			t.Errorf("Set%v takes %v, want %v", field.Name, setter.Type(), field.Type)
+			continue
+		}
+		f := reflect.MakeFunc(field.Type, func([]reflect.Value) []reflect.Value { return nil })
+		setter.Call([]reflect.Value{f})
+		if reflect.ValueOf(r.callbacks()).Field(i).IsNil() {
+			t.Errorf("Set%v didn't set it", field.Name)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// codecTrack is a local track knowing its codec, e.g. TrackLocalStaticRTP/TrackLocalStaticSample
+type codecTrack interface {
+	Codec() webrtc.RTPCodecCapability
+}
+
+// publishMimes the mimes registered for publishing, nil if unknown(custom MediaEngine)
+func (r *RTC) publishMimes(kind webrtc.RTPCodecType) []string {
+	config := r.config.WebRTC
+	if config.MediaEngine != nil {
+		return nil
+	}
+	if kind == webrtc.RTPCodecTypeAudio {
+		return []string{MimeTypeOpus}
+	}
+	var mimes []string
+	for _, codec := range videoRTPCodecParameters {
+		if config.VideoMime == "" || codec.MimeType == config.VideoMime {
+			mimes = append(mimes, codec.MimeType)
+		}
+	}
+	return mimes
+}
+
+// checkTrackCodec check the codec of a track to publish is registered, or sfu gets no media
+func (r *RTC) checkTrackCodec(track webrtc.TrackLocal) error {
+	if st, ok := track.(*streamTrack); ok {
+		track = st.TrackLocal
+	}
+	ct, ok := track.(codecTrack)
+	if !ok {
+		return nil
+	}
+	mimes := r.publishMimes(track.Kind())
+	if mimes == nil {
+		return nil
+	}
+	mime := ct.Codec().MimeType
+	for _, m := range mimes {
+		if strings.EqualFold(m, mime) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: track %v codec %v not in registered %v", errCodecMismatch, track.ID(), mime, mimes)
+}
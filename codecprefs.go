@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"strings"
+
+	log "github.com/pion/ion-log"
+	"github.com/pion/webrtc/v3"
+)
+
+// SetPreferredCodecs set the codec order of a kind by mime type(e.g. video/VP9, video/VP8), codecs not listed
+// keep their order after them. The preferences are re-applied to the transceivers before every answer to
+// sfu renegotiation, so they survive when transceivers are added or reused, no mimes clear the preferences.
+func (r *RTC) SetPreferredCodecs(kind webrtc.RTPCodecType, mimes ...string) error {
+	if kind != webrtc.RTPCodecTypeAudio && kind != webrtc.RTPCodecTypeVideo {
+		return errInvalidKind
+	}
+	r.codecLock.Lock()
+	defer r.codecLock.Unlock()
+	if len(mimes) == 0 {
+		delete(r.codecPrefs, kind)
+		return nil
+	}
+	r.codecPrefs[kind] = mimes
+	return nil
+}
+
+// applyCodecPreferences reorder the codecs of the transceivers of t by SetPreferredCodecs
+func (r *RTC) applyCodecPreferences(t *Transport) {
+	r.codecLock.Lock()
+	defer r.codecLock.Unlock()
+	if len(r.codecPrefs) == 0 {
+		return
+	}
+	for _, transceiver := range t.pc.GetTransceivers() {
+		mimes, ok := r.codecPrefs[transceiver.Kind()]
+		if !ok || transceiver.Receiver() == nil {
+			continue
+		}
+		codecs := orderCodecs(transceiver.Receiver().GetParameters().Codecs, mimes)
+		if err := transceiver.SetCodecPreferences(codecs); err != nil {
+			log.Errorf("id=%v mid=%v SetCodecPreferences err=%v", r.uid, transceiver.Mid(), err)
+		}
+	}
+}
+
+// orderCodecs move the codecs of mimes to the front by the order of mimes
+func orderCodecs(codecs []webrtc.RTPCodecParameters, mimes []string) []webrtc.RTPCodecParameters {
+	ordered := make([]webrtc.RTPCodecParameters, 0, len(codecs))
+	used := make([]bool, len(codecs))
+	for _, mime := range mimes {
+		for i, codec := range codecs {
+			if !used[i] && strings.EqualFold(codec.MimeType, mime) {
+				ordered = append(ordered, codec)
+				used[i] = true
+			}
+		}
+	}
+	for i, codec := range codecs {
+		if !used[i] {
+			ordered = append(ordered, codec)
+		}
+	}
+	return ordered
+}
@@ -1,5 +1,12 @@
 package engine
 
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
 func NewJoinConfig() *JoinConfig {
 	m := make(JoinConfig)
 	return &m
@@ -26,3 +33,59 @@ func SetRelay(j JoinConfig) *JoinConfig {
 	j["Relay"] = "true"
 	return &j
 }
+
+// Validate check obviously broken config, called when creating a RTC
+func (c *WebRTCTransportConfig) Validate() error {
+	var turn bool
+	for _, server := range c.Configuration.ICEServers {
+		if len(server.URLs) == 0 {
+			return fmt.Errorf("%w: ice server without urls", errInvalidConfig)
+		}
+		for _, url := range server.URLs {
+			switch {
+			case strings.HasPrefix(url, "stun:"), strings.HasPrefix(url, "stuns:"):
+			case strings.HasPrefix(url, "turn:"), strings.HasPrefix(url, "turns:"):
+				turn = true
+				if server.Username == "" || server.Credential == nil {
+					return fmt.Errorf("%w: turn server %v needs Username and Credential", errInvalidConfig, url)
+				}
+			default:
+				return fmt.Errorf("%w: ice server url %v should start with stun:/stuns:/turn:/turns:", errInvalidConfig, url)
+			}
+		}
+	}
+
+	relay := c.Configuration.ICETransportPolicy == webrtc.ICETransportPolicyRelay || c.ICE.RelayOnly
+	if relay && !turn {
+		return fmt.Errorf("%w: ICETransportPolicy is relay but no turn server is set, no candidate can be gathered", errInvalidConfig)
+	}
+
+	// ICE-lite gathers host candidates only
+	if c.ICE.Lite && relay {
+		return fmt.Errorf("%w: ICE.Lite has no relay candidates, ICETransportPolicy can't be relay", errInvalidConfig)
+	}
+
+	// sfu bundles all media on one transport
+	if c.Configuration.BundlePolicy == webrtc.BundlePolicyMaxCompat {
+		return fmt.Errorf("%w: BundlePolicy max-compat is not supported by sfu, use balanced or max-bundle", errInvalidConfig)
+	}
+
+	if c.VideoMime != "" && c.MediaEngine == nil {
+		supported := false
+		for _, codec := range videoRTPCodecParameters {
+			if codec.MimeType == c.VideoMime {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return fmt.Errorf("%w: VideoMime %v is not supported, register it by MediaEngine", errInvalidConfig, c.VideoMime)
+		}
+	}
+
+	// opus bitrate range, rfc7587
+	if c.Audio != nil && c.Audio.MaxAverageBitrate != 0 && (c.Audio.MaxAverageBitrate < 6000 || c.Audio.MaxAverageBitrate > 510000) {
+		return fmt.Errorf("%w: Audio.MaxAverageBitrate should be in 6000-510000", errInvalidConfig)
+	}
+	return nil
+}
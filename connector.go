@@ -6,8 +6,11 @@ import (
 	"crypto/x509"
 	"github.com/pion/ion/proto/rtc"
 	"io/ioutil"
+	"reflect"
+	"sync"
 
 	log "github.com/pion/ion-log"
+	"github.com/pion/webrtc/v3"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
@@ -19,6 +22,9 @@ type ConnectorConfig struct {
 	SSL    bool
 	Cafile string
 	Token  string
+	// shared by the RTCs created with this connector, ICE servers and SettingEngine are inherited
+	// unless set in their RTCConfig, the whole config is used if no RTCConfig
+	WebRTC *WebRTCTransportConfig
 }
 
 type ServiceEvent struct {
@@ -43,7 +49,14 @@ type Connector struct {
 	OnOpen  func(Service)
 	OnClose func(Service, ServiceEvent)
 
+	// TokenProvider is called every time a signal stream is dialed, so a refreshed token can be returned.
+	// the token is sent as "authorization" header, replacing ConnectorConfig.Token
+	TokenProvider func() (string, error)
+
+	headers metadata.MD
+
 	ctx context.Context
+	sync.Mutex
 }
 
 // NewConnector create a ion connector
@@ -51,6 +64,7 @@ func NewConnector(addr string, config ...ConnectorConfig) *Connector {
 	c := &Connector{
 		services: make(map[string]Service),
 		Metadata: make(metadata.MD),
+		headers:  make(metadata.MD),
 		ctx:      context.Background(),
 	}
 
@@ -110,10 +124,42 @@ func NewConnector(addr string, config ...ConnectorConfig) *Connector {
 func (c *Connector) Signal(r *RTC) (Signaller, error) {
 	c.RegisterService(r)
 	client := rtc.NewRTCClient(c.grpcConn)
-	r.ctx = metadata.NewOutgoingContext(r.ctx, c.Metadata)
+	md, err := c.signalMetadata()
+	if err != nil {
+		return nil, err
+	}
+	r.ctx = metadata.NewOutgoingContext(r.ctx, md)
 	return client.Signal(r.ctx)
 }
 
+// SetSignalHeaders set extra headers sent when a signal stream is dialed, e.g. for an auth proxy.
+// It is on the Connector, not the client: the stream is dialed inside NewRTC, before the client exists,
+// so set it(and TokenProvider) before NewRTC, the headers apply to every client created afterwards
+func (c *Connector) SetSignalHeaders(headers map[string]string) {
+	c.Lock()
+	defer c.Unlock()
+	for k, v := range headers {
+		c.headers.Set(k, v)
+	}
+}
+
+// signalMetadata merge Metadata, the signal headers and a fresh token
+func (c *Connector) signalMetadata() (metadata.MD, error) {
+	c.Lock()
+	md := metadata.Join(c.Metadata, c.headers)
+	c.Unlock()
+
+	if c.TokenProvider != nil {
+		token, err := c.TokenProvider()
+		if err != nil {
+			log.Errorf("TokenProvider error: %v", err)
+			return nil, err
+		}
+		md.Set("authorization", token)
+	}
+	return md, nil
+}
+
 func (c *Connector) Close() {
 	for _, s := range c.services {
 		if s.Connected() {
@@ -145,3 +191,30 @@ func (c *Connector) OnEnd(service Service, headers metadata.MD) {
 func (c *Connector) RegisterService(service Service) {
 	c.services[service.Name()] = service
 }
+
+// inheritConfig fill the RTCConfig of a new RTC with the shared WebRTC config
+func (c *Connector) inheritConfig(config ...RTCConfig) []RTCConfig {
+	if c.config == nil || c.config.WebRTC == nil {
+		return config
+	}
+	shared := c.config.WebRTC
+	if len(config) == 0 {
+		return []RTCConfig{{WebRTC: *shared}}
+	}
+
+	// copy, not modify the caller's
+	inherited := config[0]
+	if len(inherited.WebRTC.Configuration.ICEServers) == 0 {
+		inherited.WebRTC.Configuration.ICEServers = shared.Configuration.ICEServers
+		if inherited.WebRTC.Configuration.ICETransportPolicy == webrtc.ICETransportPolicyAll {
+			inherited.WebRTC.Configuration.ICETransportPolicy = shared.Configuration.ICETransportPolicy
+		}
+	}
+	if reflect.DeepEqual(inherited.WebRTC.Setting, webrtc.SettingEngine{}) {
+		inherited.WebRTC.Setting = shared.Setting
+	}
+	if reflect.DeepEqual(inherited.WebRTC.ICE, ICEConfig{}) {
+		inherited.WebRTC.ICE = shared.ICE
+	}
+	return []RTCConfig{inherited}
+}
@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"strconv"
+
+	log "github.com/pion/ion-log"
+	"github.com/pion/webrtc/v3"
+)
+
+// max message size if not in sdp, also the limit of pion sctp
+const (
+	defaultMaxMessageSize = 65536
+	// smaller remote max-message-size is ignored, it would leave no room for the chunk header
+	minMaxMessageSize = 1024
+)
+
+// addDataChannel register a custom datachannel by label
+func (r *RTC) addDataChannel(dc *webrtc.DataChannel) {
+	r.dcLock.Lock()
+	defer r.dcLock.Unlock()
+	r.dataChannels[dc.Label()] = dc
+
+	label := dc.Label()
+	dc.OnOpen(func() {
+		log.Debugf("id=%v dc %v open", r.uid, label)
+		if onOpen := r.callbacks().OnDataChannelOpen; onOpen != nil {
+			onOpen(label)
+		}
+	})
+	dc.OnClose(func() {
+		log.Debugf("id=%v dc %v close", r.uid, label)
+		r.dcLock.Lock()
+		if r.dataChannels[label] == dc {
+			delete(r.dataChannels, label)
+		}
+		r.dcLock.Unlock()
+		if onClose := r.callbacks().OnDataChannelClose; onClose != nil {
+			onClose(label)
+		}
+	})
+}
+
+// getDataChannel get a registered custom datachannel
+func (r *RTC) getDataChannel(label string) (*webrtc.DataChannel, error) {
+	r.dcLock.Lock()
+	defer r.dcLock.Unlock()
+	dc, ok := r.dataChannels[label]
+	if !ok {
+		return nil, errInvalidDataChannel
+	}
+	return dc, nil
+}
+
+// BufferedAmount return the bytes queued to send on a custom datachannel
+func (r *RTC) BufferedAmount(label string) (uint64, error) {
+	dc, err := r.getDataChannel(label)
+	if err != nil {
+		return 0, err
+	}
+	return dc.BufferedAmount(), nil
+}
+
+// SetBufferedAmountLowThreshold set the threshold of a custom datachannel,
+// f is called when the buffered amount drops to threshold, so senders can resume
+func (r *RTC) SetBufferedAmountLowThreshold(label string, threshold uint64, f func()) error {
+	dc, err := r.getDataChannel(label)
+	if err != nil {
+		return err
+	}
+	dc.SetBufferedAmountLowThreshold(threshold)
+	dc.OnBufferedAmountLow(f)
+	return nil
+}
+
+// MaxMessageSize return the max message size of custom datachannels negotiated by sdp(max-message-size),
+// not less than 1024
+func (r *RTC) MaxMessageSize() uint32 {
+	pub := r.GetPubTransport()
+	size := uint32(defaultMaxMessageSize)
+	if pub == nil {
+		return size
+	}
+	desc := pub.pc.CurrentRemoteDescription()
+	if desc == nil {
+		return size
+	}
+	parsed, err := desc.Unmarshal()
+	if err != nil {
+		return size
+	}
+	for _, md := range parsed.MediaDescriptions {
+		if md.MediaName.Media != "application" {
+			continue
+		}
+		if v, ok := md.Attribute("max-message-size"); ok {
+			// 0 means no limit of remote
+			if remote, err := strconv.ParseUint(v, 10, 32); err == nil && remote > 0 && uint32(remote) < size {
+				size = uint32(remote)
+			}
+			if size < minMaxMessageSize {
+				size = minMaxMessageSize
+			}
+		}
+	}
+	return size
+}
+
+// DataChannelConfig is the label and options of a custom datachannel created by CreateDataChannel
+type DataChannelConfig struct {
+	Label string
+	Init  *webrtc.DataChannelInit
+}
+
+// setDataChannelConfig keep the config of a created datachannel, a label created again keeps its order
+func (r *RTC) setDataChannelConfig(config DataChannelConfig) {
+	r.dcLock.Lock()
+	defer r.dcLock.Unlock()
+	for i, c := range r.dcConfigs {
+		if c.Label == config.Label {
+			r.dcConfigs[i] = config
+			return
+		}
+	}
+	r.dcConfigs = append(r.dcConfigs, config)
+}
+
+// DataChannelState return the configs of the datachannels created by CreateDataChannel in the order of creation,
+// EnablePublishing restores them itself, pass it to RestoreDataChannels of a new client after reconnect
+func (r *RTC) DataChannelState() []DataChannelConfig {
+	r.dcLock.Lock()
+	defer r.dcLock.Unlock()
+	return append([]DataChannelConfig(nil), r.dcConfigs...)
+}
+
+// RestoreDataChannels create the datachannels of a DataChannelState again in order, OnDataChannelOpen fires when
+// they open, no renegotiation is needed as the sctp transport is always negotiated for the api datachannel
+func (r *RTC) RestoreDataChannels(state []DataChannelConfig) error {
+	for _, c := range state {
+		if _, err := r.CreateDataChannel(c.Label, c.Init); err != nil {
+			log.Errorf("id=%v RestoreDataChannels label=%v err=%v", r.uid, c.Label, err)
+			return err
+		}
+	}
+	return nil
+}
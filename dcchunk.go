@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	log "github.com/pion/ion-log"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// chunk header: magic, message id(uint32), index(uint16), count(uint16)
+	chunkMagic     = 0xc1
+	chunkHeaderLen = 9
+	// incomplete messages kept by a receiver, older ones are dropped(e.g. lost on an unreliable channel)
+	maxPendingChunks = 16
+)
+
+// SendData send a binary message on a custom datachannel, larger than MaxMessageSize is rejected,
+// sctp would fail it or the remote drop it, use SendDataChunked for blobs
+func (r *RTC) SendData(label string, data []byte) error {
+	dc, err := r.getDataChannel(label)
+	if err != nil {
+		return err
+	}
+	if limit := r.MaxMessageSize(); uint32(len(data)) > limit {
+		return fmt.Errorf("%w: %d bytes, max %d", errMessageTooLarge, len(data), limit)
+	}
+	return dc.Send(data)
+}
+
+// SendDataChunked send a message of any size on a custom datachannel in chunks under MaxMessageSize,
+// the remote reassembles them by OnDataChunked, all messages on the channel must be sent chunked then.
+// Chunks are queued at once, watch BufferedAmount for large blobs.
+func (r *RTC) SendDataChunked(label string, data []byte) error {
+	dc, err := r.getDataChannel(label)
+	if err != nil {
+		return err
+	}
+	r.chunkLock.Lock()
+	r.chunkSeq++
+	id := r.chunkSeq
+	r.chunkLock.Unlock()
+
+	chunks, err := splitChunks(id, data, int(r.MaxMessageSize()))
+	if err != nil {
+		return err
+	}
+	for i, chunk := range chunks {
+		if err := dc.Send(chunk); err != nil {
+			log.Errorf("id=%v SendDataChunked label=%v chunk %d/%d err=%v", r.uid, label, i, len(chunks), err)
+			return err
+		}
+	}
+	return nil
+}
+
+// splitChunks split message id into chunks of at most maxSize bytes with the header
+func splitChunks(id uint32, data []byte, maxSize int) ([][]byte, error) {
+	size := maxSize - chunkHeaderLen
+	if size <= 0 {
+		return nil, errInvalidParams
+	}
+	count := (len(data) + size - 1) / size
+	if count == 0 {
+		count = 1
+	}
+	if count > 0xffff {
+		return nil, fmt.Errorf("%w: %d bytes, max %d chunked", errMessageTooLarge, len(data), size*0xffff)
+	}
+	chunks := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := make([]byte, chunkHeaderLen, chunkHeaderLen+end-i*size)
+		chunk[0] = chunkMagic
+		binary.BigEndian.PutUint32(chunk[1:], id)
+		binary.BigEndian.PutUint16(chunk[5:], uint16(i))
+		binary.BigEndian.PutUint16(chunk[7:], uint16(count))
+		chunks = append(chunks, append(chunk, data[i*size:end]...))
+	}
+	return chunks, nil
+}
+
+// OnDataChunked reassemble the messages sent by SendDataChunked on a custom datachannel and call f
+// with each complete one, it replaces the OnMessage of the channel
+func (r *RTC) OnDataChunked(label string, f func(data []byte)) error {
+	dc, err := r.getDataChannel(label)
+	if err != nil {
+		return err
+	}
+	assembler := &chunkAssembler{messages: make(map[uint32]*chunkMessage)}
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		data, err := assembler.push(msg.Data)
+		if err != nil {
+			log.Warnf("id=%v OnDataChunked label=%v err=%v", r.uid, label, err)
+			return
+		}
+		if data != nil {
+			f(data)
+		}
+	})
+	return nil
+}
+
+// chunkMessage is a message being reassembled
+type chunkMessage struct {
+	chunks   [][]byte
+	received int
+}
+
+// chunkAssembler reassemble chunked messages, chunks may arrive out of order on an unordered channel
+type chunkAssembler struct {
+	messages map[uint32]*chunkMessage
+	order    []uint32
+	sync.Mutex
+}
+
+// push add a chunk, return the message when complete
+func (a *chunkAssembler) push(chunk []byte) ([]byte, error) {
+	if len(chunk) < chunkHeaderLen || chunk[0] != chunkMagic {
+		return nil, errInvalidChunk
+	}
+	id := binary.BigEndian.Uint32(chunk[1:])
+	index := int(binary.BigEndian.Uint16(chunk[5:]))
+	count := int(binary.BigEndian.Uint16(chunk[7:]))
+	if count == 0 || index >= count {
+		return nil, errInvalidChunk
+	}
+	if count == 1 {
+		// the message data may be reused by the datachannel
+		return append([]byte{}, chunk[chunkHeaderLen:]...), nil
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	m, ok := a.messages[id]
+	if !ok {
+		m = &chunkMessage{chunks: make([][]byte, count)}
+		a.messages[id] = m
+		a.order = append(a.order, id)
+		if len(a.order) > maxPendingChunks {
+			delete(a.messages, a.order[0])
+			a.order = a.order[1:]
+		}
+	}
+	if len(m.chunks) != count {
+		return nil, errInvalidChunk
+	}
+	if m.chunks[index] == nil {
+		// the message data may be reused by the datachannel
+		m.chunks[index] = append([]byte(nil), chunk[chunkHeaderLen:]...)
+		m.received++
+	}
+	if m.received < count {
+		return nil, nil
+	}
+
+	delete(a.messages, id)
+	for i, o := range a.order {
+		if o == id {
+			a.order = append(a.order[:i], a.order[i+1:]...)
+			break
+		}
+	}
+	var data []byte
+	for _, c := range m.chunks {
+		data = append(data, c...)
+	}
+	return data, nil
+}
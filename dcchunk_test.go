@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSplitChunks(t *testing.T) {
+	data := []byte("0123456789abcdefghij")
+	chunks, err := splitChunks(7, data, chunkHeaderLen+8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]byte{
+		append([]byte{chunkMagic, 0, 0, 0, 7, 0, 0, 0, 3}, "01234567"...),
+		append([]byte{chunkMagic, 0, 0, 0, 7, 0, 1, 0, 3}, "89abcdef"...),
+		append([]byte{chunkMagic, 0, 0, 0, 7, 0, 2, 0, 3}, "ghij"...),
+	}
+	if len(chunks) != len(want) {
+		t.Fatalf("%v chunks, want %v", len(chunks), len(want))
+	}
+	for i := range chunks {
+		if !bytes.Equal(chunks[i], want[i]) {
+			t.Errorf("chunk %v=%v, want %v", i, chunks[i], want[i])
+		}
+	}
+
+	for _, c := range []struct {
+		name    string
+		data    []byte
+		maxSize int
+		chunks  int
+		err     error
+	}{
+		{name: "empty", data: nil, maxSize: 100, chunks: 1},
+		{name: "exact", data: make([]byte, 16), maxSize: chunkHeaderLen + 8, chunks: 2},
+		{name: "no room", data: data, maxSize: chunkHeaderLen, err: errInvalidParams},
+		{name: "too many", data: make([]byte, 0x10000), maxSize: chunkHeaderLen + 1, err: errMessageTooLarge},
+	} {
+		chunks, err := splitChunks(1, c.data, c.maxSize)
+		if c.err != nil {
+			if !errors.Is(err, c.err) {
+				t.Errorf("%v: err=%v, want %v", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil || len(chunks) != c.chunks {
+			t.Errorf("%v: %v chunks err=%v, want %v", c.name, len(chunks), err, c.chunks)
+		}
+	}
+}
+
+func TestChunkAssembler(t *testing.T) {
+	data := bytes.Repeat([]byte("chunked message "), 10)
+	chunks, err := splitChunks(1, data, chunkHeaderLen+30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reversed := make([][]byte, len(chunks))
+	for i := range chunks {
+		reversed[len(chunks)-1-i] = chunks[i]
+	}
+	duplicated := append([][]byte{chunks[0]}, chunks...)
+	single, err := splitChunks(2, []byte("one"), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name   string
+		chunks [][]byte
+		want   []byte
+	}{
+		{name: "in order", chunks: chunks, want: data},
+		{name: "out of order", chunks: reversed, want: data},
+		{name: "duplicate chunk", chunks: duplicated, want: data},
+		{name: "single", chunks: single, want: []byte("one")},
+		{name: "incomplete", chunks: chunks[1:], want: nil},
+	} {
+		a := &chunkAssembler{messages: make(map[uint32]*chunkMessage)}
+		var got []byte
+		for i, chunk := range c.chunks {
+			message, err := a.push(chunk)
+			if err != nil {
+				t.Fatalf("%v: chunk %v err=%v", c.name, i, err)
+			}
+			if message != nil {
+				if got != nil {
+					t.Fatalf("%v: message completed twice", c.name)
+				}
+				got = message
+			}
+		}
+		if !bytes.Equal(got, c.want) {
+			t.Errorf("%v: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestChunkAssemblerInvalid(t *testing.T) {
+	a := &chunkAssembler{messages: make(map[uint32]*chunkMessage)}
+	for _, c := range []struct {
+		name  string
+		chunk []byte
+	}{
+		{name: "short", chunk: []byte{chunkMagic, 0, 0}},
+		{name: "magic", chunk: []byte{0, 0, 0, 0, 1, 0, 0, 0, 1}},
+		{name: "zero count", chunk: []byte{chunkMagic, 0, 0, 0, 1, 0, 0, 0, 0}},
+		{name: "index over count", chunk: []byte{chunkMagic, 0, 0, 0, 1, 0, 2, 0, 2}},
+	} {
+		if _, err := a.push(c.chunk); !errors.Is(err, errInvalidChunk) {
+			t.Errorf("%v: err=%v", c.name, err)
+		}
+	}
+	// count changed for the same message
+	if _, err := a.push([]byte{chunkMagic, 0, 0, 0, 1, 0, 0, 0, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.push([]byte{chunkMagic, 0, 0, 0, 1, 0, 1, 0, 3}); !errors.Is(err, errInvalidChunk) {
+		t.Errorf("count mismatch err=%v", err)
+	}
+}
+
+func TestChunkAssemblerEvict(t *testing.T) {
+	a := &chunkAssembler{messages: make(map[uint32]*chunkMessage)}
+	for id := uint32(1); id <= maxPendingChunks+1; id++ {
+		if _, err := a.push([]byte{chunkMagic, 0, 0, 0, byte(id), 0, 0, 0, 2, 'a'}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(a.messages) != maxPendingChunks {
+		t.Fatalf("%v pending, want %v", len(a.messages), maxPendingChunks)
+	}
+	// the oldest was dropped, its last chunk starts a new message
+	if message, _ := a.push([]byte{chunkMagic, 0, 0, 0, 1, 0, 1, 0, 2, 'b'}); message != nil {
+		t.Errorf("evicted message completed %q", message)
+	}
+	if message, _ := a.push([]byte{chunkMagic, 0, 0, 0, maxPendingChunks + 1, 0, 1, 0, 2, 'b'}); string(message) != "ab" {
+		t.Errorf("got %q, want ab", message)
+	}
+}
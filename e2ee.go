@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"io"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+// e2eeInterceptorFactory build e2eeInterceptor for both transports
+type e2eeInterceptorFactory struct {
+	rtc *RTC
+}
+
+// NewInterceptor implements interceptor.Factory
+func (f *e2eeInterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &e2eeInterceptor{rtc: f.rtc}, nil
+}
+
+// e2eeInterceptor transform rtp payloads by OnEncrypt before sending and OnDecrypt after receiving,
+// rtp headers and extensions are kept in clear for sfu
+type e2eeInterceptor struct {
+	interceptor.NoOp
+	rtc *RTC
+}
+
+// BindLocalStream implements interceptor.Interceptor
+func (i *e2eeInterceptor) BindLocalStream(_ *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		if encrypt := i.rtc.callbacks().OnEncrypt; encrypt != nil {
+			payload = encrypt(payload)
+		}
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+// BindRemoteStream implements interceptor.Interceptor
+func (i *e2eeInterceptor) BindRemoteStream(_ *interceptor.StreamInfo, reader interceptor.RTPReader) interceptor.RTPReader {
+	return interceptor.RTPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		decrypt := i.rtc.callbacks().OnDecrypt
+		if err != nil || decrypt == nil {
+			return n, attr, err
+		}
+		header := &rtp.Header{}
+		headerSize, err := header.Unmarshal(b[:n])
+		if err != nil {
+			return n, attr, err
+		}
+		end := n
+		if header.Padding && n > headerSize {
+			end -= int(b[n-1])
+		}
+		if end < headerSize {
+			return n, attr, errInvalidParams
+		}
+		// the payload may be decrypted in place
+		payload := decrypt(append([]byte(nil), b[headerSize:end]...))
+
+		// padding is dropped
+		header.Padding = false
+		headerSize, err = header.MarshalTo(b)
+		if err != nil {
+			return n, attr, err
+		}
+		if headerSize+len(payload) > len(b) {
+			return n, attr, io.ErrShortBuffer
+		}
+		return headerSize + copy(b[headerSize:], payload), attr, nil
+	})
+}
@@ -1,14 +1,49 @@
 package engine
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotJoined is returned by the methods needing a joined client(e.g. Subscribe) before Join succeeds or after Close
+	ErrNotJoined = errors.New("not joined")
+	// ErrEncodingNotSupported is returned by SetEncodingParameters, set the parameters on the encoder of each layer
+	ErrEncodingNotSupported = errors.New("sender encoding parameters are not supported, RTPSender.SetParameters is not available")
+	// ErrAPIUnorderedNotSupported is returned by SetAPIOrdered(false), the api datachannel is created ordered by sfu
+	ErrAPIUnorderedNotSupported = errors.New("unordered api datachannel is not supported by ion-sfu")
+)
 
 var (
-	errInvalidAddr     = errors.New("invalid addr")
-	errInvalidClientID = errors.New("invalid client id")
-	errInvalidSessID   = errors.New("invalid session id")
-	errInvalidFile     = errors.New("invalid file")
-	errInvalidPC       = errors.New("invalid pc")
-	errInvalidKind     = errors.New("invalid kind, shoud be audio or video")
-	errInvalidParams   = errors.New("invalid params")
-	errReplyNil        = errors.New("reply is nil")
+	errInvalidAddr        = errors.New("invalid addr")
+	errInvalidClientID    = errors.New("invalid client id")
+	errInvalidSessID      = errors.New("invalid session id")
+	errInvalidFile        = errors.New("invalid file")
+	errInvalidPC          = errors.New("invalid pc")
+	errInvalidKind        = errors.New("invalid kind, shoud be audio or video")
+	errInvalidParams      = errors.New("invalid params")
+	errReplyNil           = errors.New("reply is nil")
+	errInvalidTrack       = errors.New("invalid track")
+	errInvalidDataChannel = errors.New("invalid datachannel")
+	errDataOnly           = errors.New("data only client can not publish media")
+	errInvalidConfig      = errors.New("invalid config")
+	errNotPublishingFile  = errors.New("not publishing a file")
+	errSDPMismatch        = errors.New("remote sdp media sections mismatch")
+	errHTTPSignal         = errors.New("http signaling failed")
+	errWHIPRenegotiation  = errors.New("renegotiation is not supported by WHIP")
+	errICEFailed          = errors.New("ice connection failed")
+	errNoPublish          = errors.New("joined with NoPublish, call EnablePublishing first")
+	errBufferFull         = errors.New("datachannel buffer full")
+	errAPISend            = errors.New("api datachannel send failed")
+	errNotJoined          = fmt.Errorf("%w by a client of NewRTC", ErrNotJoined)
+	errReadTrack          = errors.New("too many read errors of track")
+	errCodecMismatch      = errors.New("codec not registered for publishing")
+	errProducer           = errors.New("file producer failed")
+	errInvalidSDP         = errors.New("invalid remote sdp")
+	errInvalidRED         = errors.New("invalid red packet")
+	errMessageTooLarge    = errors.New("datachannel message too large")
+	errInvalidChunk       = errors.New("invalid datachannel chunk")
+	errInvalidState       = errors.New("invalid client state")
+	errUnhealthy          = errors.New("unhealthy")
+	errNoStats            = errors.New("no rtp received yet")
 )
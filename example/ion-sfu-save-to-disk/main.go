@@ -23,12 +23,16 @@ const (
 func main() {
 	// parse flag
 	var session, addr string
+	var red bool
 	flag.StringVar(&addr, "addr", "localhost:5551", "ion-sfu grpc addr")
 	flag.StringVar(&session, "session", "ion", "join session name")
+	flag.BoolVar(&red, "red", false, "receive RED/ULPFEC protected tracks")
 	flag.Parse()
 
+	config := sdk.DefaultConfig
+	config.ReceiveRED = red
 	connector := sdk.NewConnector(addr)
-	rtc, err := sdk.NewRTC(connector)
+	rtc, err := sdk.NewRTC(connector, config)
 	if err != nil {
 		panic(err)
 	}
@@ -58,10 +62,14 @@ func main() {
 		}
 		defer ivfFile.Close()
 
-		codecName := strings.Split(track.Codec().RTPCodecCapability.MimeType, "/")[1]
-		fmt.Printf("Track has started, of type %d: %s \n", track.PayloadType(), codecName)
+		// codec names by payload type, RED carries the primary codec
+		codecNames := make(map[uint8]string)
+		for _, codec := range receiver.GetParameters().Codecs {
+			codecNames[uint8(codec.PayloadType)] = strings.ToLower(strings.Split(codec.MimeType, "/")[1])
+		}
+		redReceiver := sdk.NewREDReceiver(track, receiver)
+		fmt.Printf("Track has started, of type %d: %s \n", track.PayloadType(), codecNames[uint8(track.PayloadType())])
 		buf := make([]byte, 1400)
-		rtpPacket := &rtp.Packet{}
 		for {
 			n, _, readErr := track.Read(buf)
 			if readErr != nil {
@@ -69,29 +77,21 @@ func main() {
 				return
 			}
 
-			if err = rtpPacket.Unmarshal(buf[:n]); err != nil {
+			rtpPacket := &rtp.Packet{}
+			if err = rtpPacket.Unmarshal(append([]byte(nil), buf[:n]...)); err != nil {
 				panic(err)
 			}
-
-			if codecName == "opus" {
-				log.Debugf("Got Opus track, saving to disk as output.opus (48 kHz, 2 channels)")
-
-				if err := oggFile.WriteRTP(rtpPacket); err != nil {
-					log.Panicf("Error write ogg: %v", err)
-				}
-			} else if codecName == "vp8" {
-				log.Debugf("Got VP8 track, saving to disk as output.ivf")
-
-				if len(rtpPacket.Payload) < 4 {
-					log.Debugf("Ignore packet: payload is not large enough to ivf container header, %v\n", rtpPacket)
+			rtpPackets := []*rtp.Packet{rtpPacket}
+			if redReceiver != nil {
+				// the primary and the packets recovered by the redundancy/fec
+				if rtpPackets, err = redReceiver.Unwrap(rtpPacket); err != nil {
+					log.Debugf("Ignore packet: %v", err)
 					continue
 				}
-
-				if err := ivfFile.WriteRTP(rtpPacket); err != nil {
-					log.Panicf("Error write ivf: %v", err)
-				}
 			}
-
+			for _, rtpPacket := range rtpPackets {
+				writeRTP(codecNames[rtpPacket.PayloadType], rtpPacket, oggFile, ivfFile)
+			}
 		}
 	}
 
@@ -105,3 +105,25 @@ func main() {
 
 	select {}
 }
+
+// writeRTP save a media packet by its codec
+func writeRTP(codecName string, rtpPacket *rtp.Packet, oggFile *oggwriter.OggWriter, ivfFile *ivfwriter.IVFWriter) {
+	if codecName == "opus" {
+		log.Debugf("Got Opus track, saving to disk as output.opus (48 kHz, 2 channels)")
+
+		if err := oggFile.WriteRTP(rtpPacket); err != nil {
+			log.Panicf("Error write ogg: %v", err)
+		}
+	} else if codecName == "vp8" {
+		log.Debugf("Got VP8 track, saving to disk as output.ivf")
+
+		if len(rtpPacket.Payload) < 4 {
+			log.Debugf("Ignore packet: payload is not large enough to ivf container header, %v\n", rtpPacket)
+			return
+		}
+
+		if err := ivfFile.WriteRTP(rtpPacket); err != nil {
+			log.Panicf("Error write ivf: %v", err)
+		}
+	}
+}
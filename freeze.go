@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"sync/atomic"
+	"time"
+
+	log "github.com/pion/ion-log"
+)
+
+const defaultFreezeThreshold = 2 * time.Second
+
+func (r *RTC) freezeThreshold() time.Duration {
+	if r.config == nil || r.config.FreezeThreshold == 0 {
+		return defaultFreezeThreshold
+	}
+	return r.config.FreezeThreshold
+}
+
+// watchFreeze check the last rtp time of a video track until done, fire OnVideoFreeze/OnVideoResume
+func (r *RTC) watchFreeze(trackID string, lastRTP *int64, done chan struct{}) {
+	threshold := r.freezeThreshold()
+	if threshold < 0 {
+		return
+	}
+	ticker := time.NewTicker(threshold / 4)
+	defer ticker.Stop()
+
+	var frozenAt time.Time
+	for {
+		select {
+		case <-done:
+			return
+		case <-r.notify:
+			return
+		case <-ticker.C:
+			last := time.Unix(0, atomic.LoadInt64(lastRTP))
+			since := time.Since(last)
+			switch {
+			case frozenAt.IsZero() && since >= threshold:
+				frozenAt = last
+				log.Infof("id=%v video freeze trackId=%v duration=%v", r.uid, trackID, since)
+				if onVideoFreeze := r.callbacks().OnVideoFreeze; onVideoFreeze != nil {
+					onVideoFreeze(trackID, since)
+				}
+			case !frozenAt.IsZero() && since < threshold:
+				duration := last.Sub(frozenAt)
+				frozenAt = time.Time{}
+				log.Infof("id=%v video resume trackId=%v duration=%v", r.uid, trackID, duration)
+				if onVideoResume := r.callbacks().OnVideoResume; onVideoResume != nil {
+					onVideoResume(trackID, duration)
+				}
+			}
+		}
+	}
+}
@@ -10,6 +10,7 @@ require (
 	github.com/lucsky/cuid v1.2.1
 	github.com/petar/GoLLRB v0.0.0-20210522233825-ae3b015fd3e9 // indirect
 	github.com/pion/ice/v2 v2.1.13
+	github.com/pion/interceptor v0.1.0
 	github.com/pion/ion v1.10.0
 	github.com/pion/ion-avp v1.8.4
 	github.com/pion/ion-log v1.2.1
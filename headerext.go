@@ -0,0 +1,116 @@
+package engine
+
+import (
+	"github.com/pion/interceptor"
+	log "github.com/pion/ion-log"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// maxHeaderExtensionLen the max value length of a one-byte header extension
+const maxHeaderExtensionLen = 16
+
+// registerHeaderExtensions register custom header extension uris for audio and video
+func registerHeaderExtensions(me *webrtc.MediaEngine, uris []string) error {
+	for _, uri := range uris {
+		for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+			if err := me.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: uri}, kind); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// customHeaderExtension check if uri is in WebRTCTransportConfig.HeaderExtensions
+func (r *RTC) customHeaderExtension(uri string) bool {
+	if r.config == nil {
+		return false
+	}
+	for _, u := range r.config.WebRTC.HeaderExtensions {
+		if u == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// SetHeaderExtension set the value of a custom header extension(see WebRTCTransportConfig.HeaderExtensions)
+// on the packets of a published track from now on, e.g. before each WriteSample of a sample track,
+// value is up to 16 bytes, nil stops sending it. The extension is sent only if negotiated with sfu.
+func (r *RTC) SetHeaderExtension(trackID, uri string, value []byte) error {
+	if !r.customHeaderExtension(uri) || len(value) > maxHeaderExtensionLen {
+		return errInvalidParams
+	}
+	r.headerLock.Lock()
+	defer r.headerLock.Unlock()
+	if value == nil {
+		delete(r.headerExts[trackID], uri)
+		if len(r.headerExts[trackID]) == 0 {
+			delete(r.headerExts, trackID)
+		}
+		return nil
+	}
+	if r.headerExts[trackID] == nil {
+		r.headerExts[trackID] = make(map[string][]byte)
+	}
+	// the caller may reuse value
+	r.headerExts[trackID][uri] = append([]byte(nil), value...)
+	return nil
+}
+
+// headerExtension get the values to send of a track by the negotiated extension id
+func (r *RTC) headerExtension(trackID string, ids map[string]uint8) map[uint8][]byte {
+	r.headerLock.Lock()
+	defer r.headerLock.Unlock()
+	values := r.headerExts[trackID]
+	if len(values) == 0 {
+		return nil
+	}
+	exts := make(map[uint8][]byte, len(values))
+	for uri, value := range values {
+		if id, ok := ids[uri]; ok {
+			exts[id] = value
+		}
+	}
+	return exts
+}
+
+// headerExtInterceptorFactory build headerExtInterceptor for the pub transport
+type headerExtInterceptorFactory struct {
+	rtc *RTC
+}
+
+// NewInterceptor implements interceptor.Factory
+func (f *headerExtInterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &headerExtInterceptor{rtc: f.rtc}, nil
+}
+
+// headerExtInterceptor write the custom header extensions set by SetHeaderExtension
+type headerExtInterceptor struct {
+	interceptor.NoOp
+	rtc *RTC
+}
+
+// BindLocalStream implements interceptor.Interceptor
+func (i *headerExtInterceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	ids := make(map[string]uint8)
+	for _, ext := range info.RTPHeaderExtensions {
+		if i.rtc.customHeaderExtension(ext.URI) {
+			ids[ext.URI] = uint8(ext.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return writer
+	}
+	log.Infof("id=%v track %v custom header extensions %v", i.rtc.uid, info.ID, ids)
+
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		for id, value := range i.rtc.headerExtension(info.ID, ids) {
+			if err := header.SetExtension(id, value); err != nil {
+				return 0, err
+			}
+		}
+		return writer.Write(header, payload, attributes)
+	})
+}
@@ -0,0 +1,51 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// media must have flowed within this interval for HealthCheck
+const healthProbeInterval = time.Second
+
+// HealthCheck return nil only if joined(signaling up), the ICE of both transports is connected, and rtp was sent
+// if tracks are published and received if tracks are subscribed within healthProbeInterval, for readiness probes
+// to catch "connected but no media". It returns at once, rtp is timed by the counters of the transports.
+func (r *RTC) HealthCheck() error {
+	if err := r.checkState(ClientStateJoined); err != nil {
+		return err
+	}
+	pub, sub := r.GetPubTransport(), r.GetSubTransport()
+	for _, t := range []*Transport{pub, sub} {
+		if state := t.pc.ICEConnectionState(); state != webrtc.ICEConnectionStateConnected && state != webrtc.ICEConnectionStateCompleted {
+			return fmt.Errorf("%w: target=%v ice %v", errUnhealthy, t.role, state)
+		}
+	}
+
+	stats := r.SessionStats()
+	if stats.Up.Tracks > 0 {
+		if err := mediaFlowing(pub, "published", "sent", stats.Up.Tracks); err != nil {
+			return err
+		}
+	}
+	if stats.Down.Tracks > 0 {
+		if err := mediaFlowing(sub, "subscribed", "received", stats.Down.Tracks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mediaFlowing check the last rtp of a transport with tracks is within healthProbeInterval
+func mediaFlowing(t *Transport, tracksVerb, rtpVerb string, tracks int) error {
+	last := t.stats.lastRTP()
+	if last.IsZero() {
+		return fmt.Errorf("%w: %d tracks %v, nothing %v yet", errUnhealthy, tracks, tracksVerb, rtpVerb)
+	}
+	if idle := time.Since(last); idle > healthProbeInterval {
+		return fmt.Errorf("%w: %d tracks %v, nothing %v in %v", errUnhealthy, tracks, tracksVerb, rtpVerb, idle)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"github.com/pion/webrtc/v3"
+)
+
+// ICEConfig ICE toggles of the SettingEngine of both transports, e.g. for sfu-to-sfu links
+type ICEConfig struct {
+	// ICE-lite, gather host candidates only and act as the controlled agent, the sfu must be a full agent
+	Lite bool `mapstructure:"lite"`
+	// host acceleration: public ips of a host behind 1:1 NAT(e.g. cloud instances) replace the private ones
+	// in host candidates, or are added as srflx candidates if NAT1To1Srflx, so no stun round trip is needed
+	NAT1To1IPs   []string `mapstructure:"nat1to1ips"`
+	NAT1To1Srflx bool     `mapstructure:"nat1to1srflx"`
+	// force TURN relay on both transports(Configuration.ICETransportPolicy relay), no host/srflx candidate
+	// exposes local ips, for configs decoded by mapstructure which can't set the pion enum
+	RelayOnly bool `mapstructure:"relayonly"`
+	// nominate the first valid candidate pair instead of waiting for better ones(host/srflx/relay acceptance waits)
+	AggressiveNomination bool `mapstructure:"aggressivenomination"`
+}
+
+// configuration get c with the transport policy of RelayOnly
+func (c ICEConfig) configuration(config webrtc.Configuration) webrtc.Configuration {
+	if c.RelayOnly {
+		config.ICETransportPolicy = webrtc.ICETransportPolicyRelay
+	}
+	return config
+}
+
+// apply set the toggles on a SettingEngine
+func (c ICEConfig) apply(s *webrtc.SettingEngine) {
+	if c.Lite {
+		s.SetLite(true)
+	}
+	if len(c.NAT1To1IPs) > 0 {
+		candidateType := webrtc.ICECandidateTypeHost
+		if c.NAT1To1Srflx {
+			candidateType = webrtc.ICECandidateTypeSrflx
+		}
+		s.SetNAT1To1IPs(c.NAT1To1IPs, candidateType)
+	}
+	if c.AggressiveNomination {
+		s.SetHostAcceptanceMinWait(0)
+		s.SetSrflxAcceptanceMinWait(0)
+		s.SetPrflxAcceptanceMinWait(0)
+		s.SetRelayAcceptanceMinWait(0)
+	}
+}
@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"fmt"
+
+	log "github.com/pion/ion-log"
+	"github.com/pion/webrtc/v3"
+)
+
+// PublishIsolated publish tracks on a dedicated client joining the same session as "<uid>-<n>" without subscribing,
+// so its transport, renegotiations and failures are isolated from this client and the other isolated ones.
+// The client only has the WebRTC config of this client, its tracks are not subscribed by this client.
+// The client is closed with this client, close it to unpublish the tracks. Only for clients created by NewRTC.
+func (r *RTC) PublishIsolated(tracks ...webrtc.TrackLocal) (*RTC, error) {
+	if r.connector == nil || r.sid == "" {
+		return nil, errNotJoined
+	}
+	if state := r.State(); state == ClientStateClosed {
+		return nil, fmt.Errorf("%w: %v", errInvalidState, state)
+	}
+	if len(tracks) == 0 {
+		return nil, errInvalidParams
+	}
+	// only publishing, no stats, adaptive layers or subscribe timeouts of this client
+	config := RTCConfig{
+		WebRTC:              r.config.WebRTC,
+		NoAutoSubscribe:     true,
+		NegotiationDebounce: r.config.NegotiationDebounce,
+	}
+	isolated, err := NewRTC(r.connector, config)
+	if err != nil {
+		return nil, err
+	}
+	// tracks added before Join are in the join offer
+	if _, err = isolated.Publish(tracks...); err != nil {
+		isolated.Close()
+		return nil, err
+	}
+
+	r.isolatedLock.Lock()
+	r.isolatedSeq++
+	uid := fmt.Sprintf("%v-%v", r.uid, r.isolatedSeq)
+	r.isolated[isolated] = uid
+	r.isolatedLock.Unlock()
+	isolated.SetOnClose(func(reason error) {
+		log.Infof("id=%v isolated client %v closed reason=%v", r.uid, uid, reason)
+		r.isolatedLock.Lock()
+		delete(r.isolated, isolated)
+		r.isolatedLock.Unlock()
+	})
+
+	if err = isolated.Join(r.sid, uid, NewJoinConfig().SetNoSubscribe()); err != nil {
+		isolated.Close()
+		return nil, err
+	}
+	log.Infof("id=%v PublishIsolated %v tracks as %v", r.uid, len(tracks), uid)
+	return isolated, nil
+}
+
+// closeIsolated close the clients of PublishIsolated
+func (r *RTC) closeIsolated() {
+	r.isolatedLock.Lock()
+	clients := make([]*RTC, 0, len(r.isolated))
+	for c := range r.isolated {
+		clients = append(clients, c)
+	}
+	r.isolatedLock.Unlock()
+	for _, c := range clients {
+		c.Close()
+	}
+}
+
+// isIsolatedUID check if uid is a client of PublishIsolated
+func (r *RTC) isIsolatedUID(uid string) bool {
+	r.isolatedLock.Lock()
+	defer r.isolatedLock.Unlock()
+	for _, isolatedUID := range r.isolated {
+		if isolatedUID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// dropIsolatedTracks unsubscribe the tracks of a client of PublishIsolated subscribed by sfu
+func (r *RTC) dropIsolatedTracks(event TrackEvent) {
+	if event.State != TrackEvent_ADD || r.noAutoSub {
+		return
+	}
+	var infos []*Subscription
+	for _, t := range event.Tracks {
+		infos = append(infos, &Subscription{TrackId: t.Id, Subscribe: false})
+	}
+	log.Debugf("id=%v unsubscribe isolated uid=%v tracks=%v", r.uid, event.Uid, len(infos))
+	if err := r.Subscribe(infos); err != nil {
+		log.Errorf("id=%v unsubscribe isolated uid=%v err=%v", r.uid, event.Uid, err)
+	}
+}
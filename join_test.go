@@ -0,0 +1,221 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/webrtc/v3"
+)
+
+// testSignaller record the requests sent, Recv blocks until CloseSend
+type testSignaller struct {
+	sync.Mutex
+	requests []*rtc.Request
+	sendErr  error
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newTestSignaller() *testSignaller {
+	return &testSignaller{done: make(chan struct{})}
+}
+
+func (s *testSignaller) Send(request *rtc.Request) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.sendErr != nil {
+		return s.sendErr
+	}
+	s.requests = append(s.requests, request)
+	return nil
+}
+
+func (s *testSignaller) Recv() (*rtc.Reply, error) {
+	<-s.done
+	return nil, io.EOF
+}
+
+func (s *testSignaller) CloseSend() error {
+	s.once.Do(func() { close(s.done) })
+	return nil
+}
+
+// join get the join request sent
+func (s *testSignaller) join() *rtc.JoinRequest {
+	s.Lock()
+	defer s.Unlock()
+	for _, request := range s.requests {
+		if join, ok := request.Payload.(*rtc.Request_Join); ok {
+			return join.Join
+		}
+	}
+	return nil
+}
+
+func TestJoinOfferHasTracksAndDataChannels(t *testing.T) {
+	signaller := newTestSignaller()
+	r := NewRTCWithSignaller(signaller)
+	defer r.Close()
+
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Publish(track); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.CreateDataChannel("chat"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Join("sid", "uid"); err != nil {
+		t.Fatal(err)
+	}
+
+	join := signaller.join()
+	if join == nil {
+		t.Fatal("no join request sent")
+	}
+	sdp := join.Description.Sdp
+	if !strings.Contains(sdp, "m=audio") || !strings.Contains(sdp, "msid:stream audio") {
+		t.Errorf("join offer has no audio track:\n%v", sdp)
+	}
+	if !strings.Contains(sdp, "m=application") {
+		t.Errorf("join offer has no datachannel:\n%v", sdp)
+	}
+	if !r.joinOffered() {
+		t.Error("not joined after the join request")
+	}
+}
+
+func TestJoinSendFailed(t *testing.T) {
+	signaller := newTestSignaller()
+	signaller.sendErr = errors.New("send failed")
+	r := NewRTCWithSignaller(signaller)
+	defer r.Close()
+
+	if err := r.Join("sid", "uid"); err == nil {
+		t.Fatal("Join succeeded without a join request")
+	}
+	if r.joinOffered() {
+		t.Error("joined after a failed join")
+	}
+	if state := r.State(); state != ClientStateNew {
+		t.Errorf("state=%v, want %v", state, ClientStateNew)
+	}
+}
+
+func TestNotJoined(t *testing.T) {
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no transports(e.g. failed to create them) and not joined yet
+	for name, r := range map[string]*RTC{
+		"no transports": withConfig(),
+		"not joined":    NewRTCWithSignaller(newTestSignaller()),
+	} {
+		if _, err := r.AudioQuality("audio"); !errors.Is(err, ErrNotJoined) {
+			t.Errorf("%v: AudioQuality err=%v", name, err)
+		}
+		if state := r.PublishState(); len(state) != 0 {
+			t.Errorf("%v: PublishState=%v", name, state)
+		}
+		if remap := r.MidRemap([]PublishedTrack{{Track: track, Mid: "0"}}); len(remap) != 0 {
+			t.Errorf("%v: MidRemap=%v", name, remap)
+		}
+		if tracks := r.LocalTracks(); len(tracks) != 0 {
+			t.Errorf("%v: LocalTracks=%v", name, tracks)
+		}
+		r.SetStreamLabel("stream", "label")
+		r.SessionStats()
+		r.MaxMessageSize()
+		// the adapt loop ticks with no transports or tracks
+		r.EnableAdaptiveLayers(AdaptiveConfig{Interval: time.Millisecond})
+		time.Sleep(10 * time.Millisecond)
+		r.DisableAdaptiveLayers()
+		r.Close()
+	}
+
+	r := withConfig()
+	defer r.Close()
+	if err := r.RequestKeyFrame("stream"); !errors.Is(err, ErrNotJoined) {
+		t.Errorf("RequestKeyFrame err=%v", err)
+	}
+	if _, err := r.WaitForTrack(context.Background(), "stream"); !errors.Is(err, ErrNotJoined) {
+		t.Errorf("WaitForTrack err=%v", err)
+	}
+	if _, err := r.InboundStats("track"); !errors.Is(err, ErrNotJoined) {
+		t.Errorf("InboundStats err=%v", err)
+	}
+	if _, err := r.OutboundStats("track"); !errors.Is(err, ErrNotJoined) {
+		t.Errorf("OutboundStats err=%v", err)
+	}
+	if err := r.SetSendLayerActive("track", "f", false); !errors.Is(err, ErrNotJoined) {
+		t.Errorf("SetSendLayerActive err=%v", err)
+	}
+}
+
+func TestDataChannelState(t *testing.T) {
+	r := NewRTCWithSignaller(newTestSignaller())
+	defer r.Close()
+	labels := []string{"chat", "control", "file", "cursor", "presence"}
+	for _, label := range labels {
+		if _, err := r.CreateDataChannel(label); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// created again keeps its order
+	unordered := false
+	if _, err := r.CreateDataChannel("control", &webrtc.DataChannelInit{Ordered: &unordered}); err != nil {
+		t.Fatal(err)
+	}
+	state := r.DataChannelState()
+	if len(state) != len(labels) {
+		t.Fatalf("%v datachannels, want %v", len(state), len(labels))
+	}
+	for i, c := range state {
+		if c.Label != labels[i] {
+			t.Errorf("datachannel %v=%v, want %v", i, c.Label, labels[i])
+		}
+	}
+	if init := state[1].Init; init.Ordered == nil || *init.Ordered {
+		t.Errorf("control init not replaced: %+v", init)
+	}
+
+	restored := NewRTCWithSignaller(newTestSignaller())
+	defer restored.Close()
+	if err := restored.RestoreDataChannels(state); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range restored.DataChannelState() {
+		if c.Label != labels[i] {
+			t.Errorf("restored datachannel %v=%v, want %v", i, c.Label, labels[i])
+		}
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	config := DefaultConfig
+	config.WebRTC.Configuration.ICEServers = []webrtc.ICEServer{{URLs: []string{"http://stun.example.com"}}}
+	if _, err := NewRTCWithSignallerChecked(newTestSignaller(), config); !errors.Is(err, errInvalidConfig) {
+		t.Errorf("NewRTCWithSignallerChecked err=%v", err)
+	}
+
+	signaller := newTestSignaller()
+	r := NewRTCWithSignaller(signaller, config)
+	if state := r.State(); state != ClientStateClosed {
+		t.Errorf("state=%v, want %v", state, ClientStateClosed)
+	}
+	if err := r.Join("sid", "uid"); err == nil {
+		t.Error("joined with an invalid config")
+	}
+	if join := signaller.join(); join != nil {
+		t.Error("join request sent with an invalid config")
+	}
+}
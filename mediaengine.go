@@ -1,6 +1,8 @@
 package engine
 
 import (
+	"fmt"
+
 	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v3"
 )
@@ -10,6 +12,7 @@ const (
 	MimeTypeOpus = "audio/opus"
 	MimeTypeVP8  = "video/VP8"
 	MimeTypeVP9  = "video/VP9"
+	MimeTypeAV1  = "video/AV1"
 )
 
 var (
@@ -18,6 +21,7 @@ var (
 		{Type: webrtc.TypeRTCPFBCCM, Parameter: "fir"},
 		{Type: webrtc.TypeRTCPFBNACK, Parameter: ""},
 		{Type: webrtc.TypeRTCPFBNACK, Parameter: "pli"},
+		{Type: webrtc.TypeRTCPFBTransportCC, Parameter: ""},
 	}
 	videoRTPCodecParameters = []webrtc.RTPCodecParameters{
 		{
@@ -57,10 +61,41 @@ var (
 
 const frameMarking = "urn:ietf:params:rtp-hdrext:framemarking"
 
-func getPublisherMediaEngine(mime string) (*webrtc.MediaEngine, error) {
+// AudioConfig opus fmtp parameters of the pub transport. Only the fmtp line of the pub sdp is changed: by RFC 7587
+// these are what this side prefers to receive, the sfu may take them as hints, but they never change how the
+// published audio is encoded. File samples are sent as they were encoded, configure live encoders(e.g. the
+// mediadevices opus params) with the same values. Ignored with WebRTCTransportConfig.MediaEngine.
+type AudioConfig struct {
+	// discontinuous transmission, save bandwidth when silence
+	DTX bool
+	// inband forward error correction
+	FEC bool
+	// target bitrate in bps, 0 means default
+	MaxAverageBitrate uint32
+}
+
+// fmtpLine build the opus fmtp line, nil config keeps the default
+func (a *AudioConfig) fmtpLine() string {
+	if a == nil {
+		return "minptime=10;useinbandfec=1"
+	}
+	line := "minptime=10"
+	if a.FEC {
+		line += ";useinbandfec=1"
+	}
+	if a.DTX {
+		line += ";usedtx=1"
+	}
+	if a.MaxAverageBitrate > 0 {
+		line += fmt.Sprintf(";maxaveragebitrate=%d", a.MaxAverageBitrate)
+	}
+	return line
+}
+
+func getPublisherMediaEngine(mime string, audio *AudioConfig) (*webrtc.MediaEngine, error) {
 	me := &webrtc.MediaEngine{}
 	if err := me.RegisterCodec(webrtc.RTPCodecParameters{
-		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1", RTCPFeedback: nil},
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: audio.fmtpLine(), RTCPFeedback: []webrtc.RTCPFeedback{{Type: webrtc.TypeRTCPFBTransportCC}}},
 		PayloadType:        111,
 	}, webrtc.RTPCodecTypeAudio); err != nil {
 		return nil, err
@@ -96,6 +131,8 @@ func getPublisherMediaEngine(mime string) (*webrtc.MediaEngine, error) {
 		sdp.SDESMidURI,
 		sdp.SDESRTPStreamIDURI,
 		sdp.AudioLevelURI,
+		// audio counts in the send estimate too
+		sdp.TransportCCURI,
 	} {
 		if err := me.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: extension}, webrtc.RTPCodecTypeAudio); err != nil {
 			return nil, err
@@ -108,5 +145,9 @@ func getPublisherMediaEngine(mime string) (*webrtc.MediaEngine, error) {
 func getSubscriberMediaEngine() (*webrtc.MediaEngine, error) {
 	me := &webrtc.MediaEngine{}
 	_ = me.RegisterDefaultCodecs()
+	// for GetAudioLevel
+	if err := me.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: sdp.AudioLevelURI}, webrtc.RTPCodecTypeAudio); err != nil {
+		return nil, err
+	}
 	return me, nil
 }
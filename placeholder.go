@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/pion/ion-log"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+var (
+	// a black(Y=16) 320x240 VP8 keyframe, DC predicted from the first macroblock
+	blackVP8Frame = []byte{
+		0x30, 0x11, 0x00, 0x9d, 0x01, 0x2a, 0x40, 0x01, 0xf0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x18,
+		0x58, 0x13, 0xdc, 0x1a, 0x05, 0x92, 0x7d, 0xaf, 0x6e, 0x6c, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec,
+		0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c,
+		0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1,
+		0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec,
+		0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c,
+		0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1,
+		0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec,
+		0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c, 0xe1, 0xec, 0x9c,
+		0xe1, 0xeb, 0xd0, 0xfe, 0xff, 0xdf, 0x6e, 0xd4, 0x00,
+	}
+	// a 20ms opus silence frame
+	silenceOpusFrame = []byte{0xf8, 0xff, 0xfe}
+)
+
+const (
+	// black frames are repeated slowly, each is a keyframe
+	placeholderVideoInterval = time.Second
+	placeholderAudioInterval = 20 * time.Millisecond
+)
+
+// PublishPlaceholder publish a black video(VP8) or silent audio(opus) track, e.g. camera/mic off,
+// replace it with real media later by sender.ReplaceTrack without renegotiation, the placeholder stops then
+func (r *RTC) PublishPlaceholder(kind webrtc.RTPCodecType) (*webrtc.RTPSender, error) {
+	var codec webrtc.RTPCodecCapability
+	var frame []byte
+	var interval time.Duration
+	switch kind {
+	case webrtc.RTPCodecTypeVideo:
+		codec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}
+		frame, interval = blackVP8Frame, placeholderVideoInterval
+	case webrtc.RTPCodecTypeAudio:
+		codec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}
+		frame, interval = silenceOpusFrame, placeholderAudioInterval
+	default:
+		return nil, errInvalidKind
+	}
+	track, err := webrtc.NewTrackLocalStaticSample(codec, kind.String(), fmt.Sprintf("placeholder_%v", RandomKey(6)))
+	if err != nil {
+		return nil, err
+	}
+	senders, err := r.Publish(track)
+	if err != nil {
+		return nil, err
+	}
+	go r.writePlaceholder(senders[0], track, frame, interval)
+	return senders[0], nil
+}
+
+// writePlaceholder write the frame every interval until the track is replaced/unpublished or Close
+func (r *RTC) writePlaceholder(sender *webrtc.RTPSender, track *webrtc.TrackLocalStaticSample, frame []byte, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			if sender.Track() != track {
+				log.Infof("id=%v placeholder %v replaced", r.uid, track.ID())
+				return
+			}
+			if err := track.WriteSample(media.Sample{Data: frame, Duration: interval}); err != nil {
+				log.Debugf("id=%v placeholder write err=%v", r.uid, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"time"
+
+	log "github.com/pion/ion-log"
+	"github.com/pion/rtcp"
+)
+
+const defaultPLIInterval = 500 * time.Millisecond
+
+func (r *RTC) pliInterval() time.Duration {
+	if r.config == nil || r.config.PLIInterval == 0 {
+		return defaultPLIInterval
+	}
+	return r.config.PLIInterval
+}
+
+// writePLI send PLIs to the subscribed ssrcs, at most one per ssrc in pliInterval,
+// requests in the interval are coalesced into one PLI sent when the interval ends
+func (r *RTC) writePLI(ssrcs []uint32) error {
+	sub := r.GetSubTransport()
+	interval := r.pliInterval()
+	var pkts []rtcp.Packet
+
+	r.pliLock.Lock()
+	for _, ssrc := range ssrcs {
+		if interval < 0 {
+			pkts = append(pkts, &rtcp.PictureLossIndication{MediaSSRC: ssrc})
+			continue
+		}
+		if r.pliPending[ssrc] {
+			continue
+		}
+		wait := interval - time.Since(r.pliSent[ssrc])
+		if wait <= 0 {
+			r.pliSent[ssrc] = time.Now()
+			pkts = append(pkts, &rtcp.PictureLossIndication{MediaSSRC: ssrc})
+			continue
+		}
+		r.pliPending[ssrc] = true
+		ssrc := ssrc
+		time.AfterFunc(wait, func() {
+			r.pliLock.Lock()
+			delete(r.pliPending, ssrc)
+			r.pliSent[ssrc] = time.Now()
+			r.pliLock.Unlock()
+			if err := sub.pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: ssrc}}); err != nil {
+				log.Debugf("id=%v write pli ssrc=%v err=%v", r.uid, ssrc, err)
+			}
+		})
+	}
+	r.pliLock.Unlock()
+
+	if len(pkts) == 0 {
+		return nil
+	}
+	return sub.pc.WriteRTCP(pkts)
+}
@@ -0,0 +1,136 @@
+package engine
+
+import (
+	"sort"
+
+	log "github.com/pion/ion-log"
+	"github.com/pion/webrtc/v3"
+)
+
+// PublishedTrack is a published track and its config, to republish after reconnect
+type PublishedTrack struct {
+	Track webrtc.TrackLocal
+	// stream label set by SetStreamLabel
+	Label string
+	// rid if sent as a simulcast layer(PublishFileRID)
+	RID string
+	// mid negotiated, empty if not negotiated yet
+	Mid string
+}
+
+// trackPublished remember a sender for PublishState
+func (r *RTC) trackPublished(sender *webrtc.RTPSender) {
+	r.pubLock.Lock()
+	defer r.pubLock.Unlock()
+	r.pubSenders[sender] = true
+}
+
+// trackUnpublished forget a sender
+func (r *RTC) trackUnpublished(sender *webrtc.RTPSender) {
+	r.pubLock.Lock()
+	defer r.pubLock.Unlock()
+	delete(r.pubSenders, sender)
+}
+
+// PublishState snapshot the published tracks(by Publish, PublishFile, AddLocalTrackRTP...),
+// pass it to RestorePublish of the client after reconnect
+func (r *RTC) PublishState() []PublishedTrack {
+	pub := r.GetPubTransport()
+	if pub == nil {
+		return nil
+	}
+	r.pubLock.Lock()
+	senders := make([]*webrtc.RTPSender, 0, len(r.pubSenders))
+	for sender := range r.pubSenders {
+		senders = append(senders, sender)
+	}
+	r.pubLock.Unlock()
+
+	mids := make(map[*webrtc.RTPSender]string)
+	for _, t := range pub.pc.GetTransceivers() {
+		if t.Sender() != nil {
+			mids[t.Sender()] = t.Mid()
+		}
+	}
+
+	var state []PublishedTrack
+	for _, sender := range senders {
+		// current track, may be replaced
+		track := sender.Track()
+		if track == nil {
+			continue
+		}
+		r.labelLock.Lock()
+		label := r.streamLabels[track.StreamID()]
+		r.labelLock.Unlock()
+		r.ridLock.Lock()
+		rid := r.trackRIDs[track.ID()]
+		r.ridLock.Unlock()
+		state = append(state, PublishedTrack{Track: track, Label: label, RID: rid, Mid: mids[sender]})
+	}
+	sortByMid(state)
+	return state
+}
+
+// sortByMid sort tracks in the order of mids("0" < "1" < "10"), not negotiated at last
+func sortByMid(state []PublishedTrack) {
+	sort.SliceStable(state, func(i, j int) bool {
+		a, b := state[i].Mid, state[j].Mid
+		if a == "" || b == "" {
+			return a != ""
+		}
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+}
+
+// RestorePublish publish the tracks of a PublishState again, e.g. on a new client after reconnect,
+// file producers keep writing the same tracks with the same track ids. Tracks are added in the order of
+// their old mids, so the mids are the same if restored before publishing anything else, see MidRemap
+func (r *RTC) RestorePublish(state []PublishedTrack) error {
+	if len(state) == 0 {
+		return nil
+	}
+	state = append([]PublishedTrack(nil), state...)
+	sortByMid(state)
+	tracks := make([]webrtc.TrackLocal, 0, len(state))
+	for _, t := range state {
+		if t.Label != "" {
+			r.SetStreamLabel(t.Track.StreamID(), t.Label)
+		}
+		if t.RID != "" {
+			r.setTrackRID(t.Track.ID(), t.RID)
+		}
+		tracks = append(tracks, t.Track)
+	}
+	log.Infof("id=%v RestorePublish %v tracks", r.uid, len(tracks))
+	_, err := r.Publish(tracks...)
+	return err
+}
+
+// MidRemap map the old mids of a PublishState to the mids negotiated now by track id, for apps keeping
+// state by mid across reconnect, tracks not negotiated yet are omitted
+func (r *RTC) MidRemap(state []PublishedTrack) map[string]string {
+	pub := r.GetPubTransport()
+	mids := make(map[string]string)
+	remap := make(map[string]string)
+	if pub == nil {
+		return remap
+	}
+	for _, t := range pub.pc.GetTransceivers() {
+		if track := t.Sender().Track(); track != nil && t.Mid() != "" {
+			mids[track.ID()] = t.Mid()
+		}
+	}
+	for _, t := range state {
+		if mid, ok := mids[t.Track.ID()]; ok && t.Mid != "" {
+			remap[t.Mid] = mid
+			if mid != t.Mid {
+				log.Warnf("id=%v trackId=%v mid changed %v => %v", r.uid, t.Track.ID(), t.Mid, mid)
+			}
+		}
+	}
+	return remap
+}
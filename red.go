@@ -0,0 +1,324 @@
+package engine
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// RED(rfc2198) wraps the primary payload with redundant copies of the previous ones(audio) or ULPFEC(rfc5109)
+// blocks(video), browsers send it on lossy links if negotiated
+const (
+	MimeTypeAudioRED = "audio/red"
+	MimeTypeVideoRED = "video/red"
+	MimeTypeULPFEC   = "video/ulpfec"
+)
+
+const (
+	// media packets kept for fec recovery and dropping duplicates
+	redHistorySize = 64
+	// fec packets waiting for the packets they protect
+	maxPendingFEC = 16
+	// fec header and the level 0 header with the short mask
+	fecHeaderLen   = 10
+	fecLevelHdrLen = 4
+)
+
+// registerRED register audio/red(opus primary), video/red and video/ulpfec carried in it for the sub transport,
+// see ReceiveRED
+func registerRED(me *webrtc.MediaEngine) error {
+	if err := me.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: MimeTypeAudioRED, ClockRate: 48000, Channels: 2, SDPFmtpLine: "111/111"},
+		PayloadType:        63,
+	}, webrtc.RTPCodecTypeAudio); err != nil {
+		return err
+	}
+	if err := me.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: MimeTypeVideoRED, ClockRate: 90000},
+		PayloadType:        114,
+	}, webrtc.RTPCodecTypeVideo); err != nil {
+		return err
+	}
+	return me.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: MimeTypeULPFEC, ClockRate: 90000},
+		PayloadType:        117,
+	}, webrtc.RTPCodecTypeVideo)
+}
+
+// redBlock is a block of a RED payload
+type redBlock struct {
+	pt       uint8
+	tsOffset uint32
+	length   int
+	data     []byte
+}
+
+// parseRED split a RED payload into blocks, the primary is the last
+func parseRED(payload []byte) ([]redBlock, error) {
+	var blocks []redBlock
+	i := 0
+	for {
+		if i >= len(payload) {
+			return nil, errInvalidRED
+		}
+		// F=0, the primary header is one byte
+		if payload[i]&0x80 == 0 {
+			blocks = append(blocks, redBlock{pt: payload[i] & 0x7f})
+			i++
+			break
+		}
+		if i+4 > len(payload) {
+			return nil, errInvalidRED
+		}
+		blocks = append(blocks, redBlock{
+			pt:       payload[i] & 0x7f,
+			tsOffset: uint32(payload[i+1])<<6 | uint32(payload[i+2])>>2,
+			length:   int(payload[i+2]&0x03)<<8 | int(payload[i+3]),
+		})
+		i += 4
+	}
+	for j := range blocks {
+		if j == len(blocks)-1 {
+			blocks[j].data = payload[i:]
+			break
+		}
+		if i+blocks[j].length > len(payload) {
+			return nil, errInvalidRED
+		}
+		blocks[j].data = payload[i : i+blocks[j].length]
+		i += blocks[j].length
+	}
+	return blocks, nil
+}
+
+// fecPacket is a parsed ULPFEC packet, only level 0 is used
+type fecPacket struct {
+	ssrc uint32
+	// the fec header, xor of the protected headers(P/X/CC, M/PT, TS) and lengths
+	header  []byte
+	seqs    []uint16
+	payload []byte
+}
+
+// parseFEC parse a ULPFEC payload protecting packets of ssrc
+func parseFEC(ssrc uint32, data []byte) (*fecPacket, error) {
+	if len(data) < fecHeaderLen+fecLevelHdrLen {
+		return nil, errInvalidRED
+	}
+	maskLen := 2
+	// L bit, 48 bits mask
+	if data[0]&0x40 != 0 {
+		maskLen = 6
+	}
+	if len(data) < fecHeaderLen+2+maskLen {
+		return nil, errInvalidRED
+	}
+	protectionLen := int(binary.BigEndian.Uint16(data[fecHeaderLen:]))
+	payload := data[fecHeaderLen+2+maskLen:]
+	if len(payload) < protectionLen {
+		return nil, errInvalidRED
+	}
+	snBase := binary.BigEndian.Uint16(data[2:])
+	mask := data[fecHeaderLen+2 : fecHeaderLen+2+maskLen]
+	f := &fecPacket{
+		ssrc:    ssrc,
+		header:  append([]byte(nil), data[:fecHeaderLen]...),
+		payload: append([]byte(nil), payload[:protectionLen]...),
+	}
+	for i := 0; i < maskLen*8; i++ {
+		if mask[i/8]&(0x80>>(i%8)) != 0 {
+			f.seqs = append(f.seqs, snBase+uint16(i))
+		}
+	}
+	return f, nil
+}
+
+// REDReceiver unwrap the RED packets of a remote track into the primary media packets, lost packets are
+// recovered from the redundant blocks(audio, assumed to be the previous packets) and ULPFEC(video).
+// The default read loop uses one for SubscribeRTP and EnableCapture, use it in OnTrack handlers(e.g. a recorder)
+// to depacketize RED tracks.
+type REDReceiver struct {
+	redPT uint8
+	// 0 if ulpfec is not negotiated
+	fecPT uint8
+
+	history map[uint16]*rtp.Packet
+	order   []uint16
+	// highest seq received
+	highest uint16
+	started bool
+	pending []*fecPacket
+}
+
+// NewREDReceiver create a REDReceiver for a track, nil if the track is not RED
+func NewREDReceiver(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) *REDReceiver {
+	mime := track.Codec().MimeType
+	if !strings.EqualFold(mime, MimeTypeAudioRED) && !strings.EqualFold(mime, MimeTypeVideoRED) {
+		return nil
+	}
+	red := &REDReceiver{
+		redPT:   uint8(track.PayloadType()),
+		history: make(map[uint16]*rtp.Packet),
+	}
+	for _, codec := range receiver.GetParameters().Codecs {
+		if strings.EqualFold(codec.MimeType, MimeTypeULPFEC) {
+			red.fecPT = uint8(codec.PayloadType)
+		}
+	}
+	return red
+}
+
+// Unwrap return the media packets carried by pkt, recovered ones first, duplicates are dropped,
+// packets not RED are passed through(or used for recovery if ULPFEC), pkt must not be reused by the caller
+func (red *REDReceiver) Unwrap(pkt *rtp.Packet) ([]*rtp.Packet, error) {
+	if pkt.PayloadType != red.redPT {
+		if red.fecPT != 0 && pkt.PayloadType == red.fecPT {
+			return red.addFEC(pkt.SSRC, pkt.Payload), nil
+		}
+		return red.addMedia(pkt), nil
+	}
+	blocks, err := parseRED(pkt.Payload)
+	if err != nil {
+		return nil, err
+	}
+	var pkts []*rtp.Packet
+	for i, block := range blocks {
+		redundant := i < len(blocks)-1
+		if red.fecPT != 0 && block.pt == red.fecPT {
+			pkts = append(pkts, red.addFEC(pkt.SSRC, block.data)...)
+			continue
+		}
+		header := pkt.Header
+		header.PayloadType = block.pt
+		header.Timestamp -= block.tsOffset
+		header.Padding = false
+		if redundant {
+			header.SequenceNumber -= uint16(len(blocks) - 1 - i)
+			header.Marker = false
+			header.Extension = false
+			header.Extensions = nil
+			// too old to tell if it was received
+			if red.started && int16(red.highest-header.SequenceNumber) >= redHistorySize {
+				continue
+			}
+		}
+		pkts = append(pkts, red.addMedia(&rtp.Packet{Header: header, Payload: block.data})...)
+	}
+	return pkts, nil
+}
+
+// addMedia keep a media packet, return it and the packets recovered with it, nil if duplicate
+func (red *REDReceiver) addMedia(pkt *rtp.Packet) []*rtp.Packet {
+	if _, ok := red.history[pkt.SequenceNumber]; ok {
+		return nil
+	}
+	red.keep(pkt)
+	return append([]*rtp.Packet{pkt}, red.recover()...)
+}
+
+// addFEC keep a fec packet, return the packets recovered
+func (red *REDReceiver) addFEC(ssrc uint32, data []byte) []*rtp.Packet {
+	f, err := parseFEC(ssrc, data)
+	if err != nil {
+		return nil
+	}
+	red.pending = append(red.pending, f)
+	if len(red.pending) > maxPendingFEC {
+		red.pending = red.pending[len(red.pending)-maxPendingFEC:]
+	}
+	return red.recover()
+}
+
+// keep add a packet to the history
+func (red *REDReceiver) keep(pkt *rtp.Packet) {
+	red.history[pkt.SequenceNumber] = pkt
+	red.order = append(red.order, pkt.SequenceNumber)
+	if len(red.order) > redHistorySize {
+		delete(red.history, red.order[0])
+		red.order = red.order[1:]
+	}
+	if !red.started || int16(pkt.SequenceNumber-red.highest) > 0 {
+		red.highest, red.started = pkt.SequenceNumber, true
+	}
+}
+
+// recover the packets protected by pending fec packets with exactly one missing,
+// fec packets with none missing or too old are dropped
+func (red *REDReceiver) recover() []*rtp.Packet {
+	var recovered []*rtp.Packet
+	for again := true; again; {
+		again = false
+		pending := red.pending[:0]
+		for _, f := range red.pending {
+			var missing []uint16
+			old := false
+			for _, seq := range f.seqs {
+				if _, ok := red.history[seq]; !ok {
+					missing = append(missing, seq)
+					old = old || int16(red.highest-seq) >= redHistorySize
+				}
+			}
+			switch {
+			case len(missing) == 0 || old:
+			case len(missing) == 1:
+				if pkt := red.recoverPacket(f, missing[0]); pkt != nil {
+					red.keep(pkt)
+					recovered = append(recovered, pkt)
+					// may complete another fec packet
+					again = true
+				}
+			default:
+				pending = append(pending, f)
+			}
+		}
+		red.pending = pending
+	}
+	return recovered
+}
+
+// recoverPacket rebuild the missing packet seq by xor of the fec packet and the other protected packets
+func (red *REDReceiver) recoverPacket(f *fecPacket, seq uint16) *rtp.Packet {
+	header := append([]byte(nil), f.header...)
+	payload := append([]byte(nil), f.payload...)
+	for _, s := range f.seqs {
+		if s == seq {
+			continue
+		}
+		raw, err := red.history[s].Marshal()
+		if err != nil || len(raw) < 12 {
+			return nil
+		}
+		header[0] ^= raw[0]
+		header[1] ^= raw[1]
+		for i := 4; i < 8; i++ {
+			header[i] ^= raw[i]
+		}
+		length := binary.BigEndian.Uint16(header[8:]) ^ uint16(len(raw)-12)
+		binary.BigEndian.PutUint16(header[8:], length)
+		for i, b := range raw[12:] {
+			if i >= len(payload) {
+				break
+			}
+			payload[i] ^= b
+		}
+	}
+	length := int(binary.BigEndian.Uint16(header[8:]))
+	// not fully protected
+	if length > len(payload) {
+		return nil
+	}
+	raw := make([]byte, 12+length)
+	raw[0] = 0x80 | header[0]&0x3f
+	raw[1] = header[1]
+	binary.BigEndian.PutUint16(raw[2:], seq)
+	copy(raw[4:8], header[4:8])
+	binary.BigEndian.PutUint32(raw[8:], f.ssrc)
+	copy(raw[12:], payload[:length])
+	pkt := &rtp.Packet{}
+	if err := pkt.Unmarshal(raw); err != nil {
+		return nil
+	}
+	return pkt
+}
@@ -0,0 +1,232 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	testOpusPT = 111
+	testREDPT  = 114
+	testFECPT  = 117
+	testSSRC   = 1234
+)
+
+// redPayload build a RED payload of redundant blocks(pt, ts offset, data) and the primary
+func redPayload(primaryPT uint8, primary []byte, redundant ...redBlock) []byte {
+	var header, data []byte
+	for _, b := range redundant {
+		header = append(header, 0x80|b.pt, byte(b.tsOffset>>6), byte(b.tsOffset<<2)|byte(len(b.data)>>8), byte(len(b.data)))
+		data = append(data, b.data...)
+	}
+	header = append(header, primaryPT)
+	return append(append(header, data...), primary...)
+}
+
+// ulpfec build a ULPFEC payload(level 0, short mask) protecting pkts, the first is the sequence base
+func ulpfec(pkts ...*rtp.Packet) []byte {
+	header := make([]byte, fecHeaderLen)
+	var payload []byte
+	mask := uint16(0)
+	base := pkts[0].SequenceNumber
+	for _, pkt := range pkts {
+		raw, err := pkt.Marshal()
+		if err != nil {
+			panic(err)
+		}
+		header[0] ^= raw[0]
+		header[1] ^= raw[1]
+		for i := 4; i < 8; i++ {
+			header[i] ^= raw[i]
+		}
+		binary.BigEndian.PutUint16(header[8:], binary.BigEndian.Uint16(header[8:])^uint16(len(raw)-12))
+		for len(payload) < len(raw)-12 {
+			payload = append(payload, 0)
+		}
+		for i, b := range raw[12:] {
+			payload[i] ^= b
+		}
+		mask |= 0x8000 >> (pkt.SequenceNumber - base)
+	}
+	// E and L bits cleared
+	header[0] &= 0x3f
+	binary.BigEndian.PutUint16(header[2:], base)
+	level := make([]byte, fecLevelHdrLen)
+	binary.BigEndian.PutUint16(level, uint16(len(payload)))
+	binary.BigEndian.PutUint16(level[2:], mask)
+	return append(append(header, level...), payload...)
+}
+
+func mediaPacket(seq uint16, ts uint32, marker bool, payload []byte) *rtp.Packet {
+	return &rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: 96, SequenceNumber: seq, Timestamp: ts, SSRC: testSSRC, Marker: marker},
+		Payload: payload,
+	}
+}
+
+func TestParseRED(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		payload []byte
+		want    []redBlock
+		err     bool
+	}{
+		{
+			name:    "primary only",
+			payload: redPayload(testOpusPT, []byte{1, 2, 3}),
+			want:    []redBlock{{pt: testOpusPT, data: []byte{1, 2, 3}}},
+		},
+		{
+			name:    "two redundant",
+			payload: redPayload(testOpusPT, []byte{3}, redBlock{pt: testOpusPT, tsOffset: 1920, data: []byte{1}}, redBlock{pt: testOpusPT, tsOffset: 960, data: []byte{2, 2}}),
+			want: []redBlock{
+				{pt: testOpusPT, tsOffset: 1920, length: 1, data: []byte{1}},
+				{pt: testOpusPT, tsOffset: 960, length: 2, data: []byte{2, 2}},
+				{pt: testOpusPT, data: []byte{3}},
+			},
+		},
+		{name: "empty", payload: nil, err: true},
+		{name: "truncated header", payload: []byte{0x80 | testOpusPT, 0, 0}, err: true},
+		{name: "block over payload", payload: []byte{0x80 | testOpusPT, 0, 0, 5, testOpusPT, 1}, err: true},
+	} {
+		blocks, err := parseRED(c.payload)
+		if c.err {
+			if err == nil {
+				t.Errorf("%v: no error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: err=%v", c.name, err)
+			continue
+		}
+		if len(blocks) != len(c.want) {
+			t.Errorf("%v: %v blocks, want %v", c.name, len(blocks), len(c.want))
+			continue
+		}
+		for i, b := range blocks {
+			w := c.want[i]
+			if b.pt != w.pt || b.tsOffset != w.tsOffset || b.length != w.length || !bytes.Equal(b.data, w.data) {
+				t.Errorf("%v: block %v=%+v, want %+v", c.name, i, b, w)
+			}
+		}
+	}
+}
+
+func TestParseFEC(t *testing.T) {
+	valid := ulpfec(mediaPacket(100, 0, false, []byte{1}), mediaPacket(102, 0, false, []byte{2, 2}))
+	long := append([]byte(nil), valid...)
+	long[0] |= 0x40
+	short := append([]byte(nil), valid...)
+	binary.BigEndian.PutUint16(short[fecHeaderLen:], 100)
+	for _, c := range []struct {
+		name string
+		data []byte
+		seqs []uint16
+		err  bool
+	}{
+		{name: "short mask", data: valid, seqs: []uint16{100, 102}},
+		{name: "too short", data: valid[:fecHeaderLen+2], err: true},
+		{name: "long mask over payload", data: long[:fecHeaderLen+fecLevelHdrLen+2], err: true},
+		{name: "protection over payload", data: short, err: true},
+	} {
+		f, err := parseFEC(testSSRC, c.data)
+		if c.err {
+			if err == nil {
+				t.Errorf("%v: no error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: err=%v", c.name, err)
+			continue
+		}
+		if len(f.seqs) != len(c.seqs) {
+			t.Errorf("%v: seqs=%v, want %v", c.name, f.seqs, c.seqs)
+			continue
+		}
+		for i := range f.seqs {
+			if f.seqs[i] != c.seqs[i] {
+				t.Errorf("%v: seqs=%v, want %v", c.name, f.seqs, c.seqs)
+			}
+		}
+	}
+}
+
+func TestREDRecovery(t *testing.T) {
+	p100 := mediaPacket(100, 3000, false, []byte{1, 2, 3})
+	p101 := mediaPacket(101, 3000, true, []byte{4, 5})
+	p102 := mediaPacket(102, 6000, false, []byte{6, 7, 8, 9})
+	fec := ulpfec(p100, p101, p102)
+
+	// wrap a media packet like the sfu forwards it
+	red := func(pkt *rtp.Packet, redundant ...redBlock) *rtp.Packet {
+		header := pkt.Header
+		header.PayloadType = testREDPT
+		return &rtp.Packet{Header: header, Payload: redPayload(pkt.PayloadType, pkt.Payload, redundant...)}
+	}
+	for _, c := range []struct {
+		name string
+		pkts []*rtp.Packet
+		want []*rtp.Packet
+	}{
+		{
+			name: "fec recovers one lost",
+			pkts: []*rtp.Packet{
+				red(p100),
+				red(p102),
+				{Header: rtp.Header{Version: 2, PayloadType: testREDPT, SequenceNumber: 103, SSRC: testSSRC}, Payload: redPayload(testFECPT, fec)},
+			},
+			want: []*rtp.Packet{p100, p102, p101},
+		},
+		{
+			name: "fec before the media",
+			pkts: []*rtp.Packet{
+				{Header: rtp.Header{Version: 2, PayloadType: testFECPT, SequenceNumber: 103, SSRC: testSSRC}, Payload: fec},
+				red(p100),
+				red(p101),
+			},
+			want: []*rtp.Packet{p100, p101, p102},
+		},
+		{
+			name: "redundant block recovers the previous",
+			pkts: []*rtp.Packet{
+				red(p100),
+				red(p102, redBlock{pt: 96, tsOffset: 3000, data: p101.Payload}),
+			},
+			want: []*rtp.Packet{p100, mediaPacket(101, 3000, false, p101.Payload), p102},
+		},
+		{
+			name: "duplicates dropped",
+			pkts: []*rtp.Packet{
+				red(p100),
+				red(p101, redBlock{pt: 96, data: p100.Payload}),
+			},
+			want: []*rtp.Packet{p100, p101},
+		},
+	} {
+		receiver := &REDReceiver{redPT: testREDPT, fecPT: testFECPT, history: make(map[uint16]*rtp.Packet)}
+		var got []*rtp.Packet
+		for _, pkt := range c.pkts {
+			pkts, err := receiver.Unwrap(pkt)
+			if err != nil {
+				t.Fatalf("%v: err=%v", c.name, err)
+			}
+			got = append(got, pkts...)
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("%v: got %v packets, want %v", c.name, len(got), len(c.want))
+			continue
+		}
+		for i, pkt := range got {
+			w := c.want[i]
+			if pkt.SequenceNumber != w.SequenceNumber || pkt.Timestamp != w.Timestamp || pkt.PayloadType != w.PayloadType ||
+				pkt.Marker != w.Marker || !bytes.Equal(pkt.Payload, w.Payload) {
+				t.Errorf("%v: packet %v=%v %v, want %v %v", c.name, i, pkt.Header, pkt.Payload, w.Header, w.Payload)
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package engine
+
+import (
+	log "github.com/pion/ion-log"
+)
+
+// EnablePublishing upgrade a client joined with NoPublish. ion-sfu creates no publisher for such a peer and rejects
+// its offers, so the client joins the session again with the same sid/uid and join config without NoPublish, on a
+// new signal stream and new transports(the sfu closes the old peer with its stream). Subscribed tracks arrive again
+// by OnTrack, the layers selected by api are sent again and auto subscriptions are made again by the new track
+// events, datachannels created by CreateDataChannel are created again(OnDataChannelOpen fires). Publishing waits
+// until it's done, if joining again fails the client is closed with the error. Only for clients created by NewRTC.
+func (r *RTC) EnablePublishing() error {
+	if r.config.DataOnly {
+		return errDataOnly
+	}
+	if err := r.checkJoined(); err != nil {
+		return err
+	}
+	r.noPubLock.Lock()
+	defer r.noPubLock.Unlock()
+	if !r.noPublish {
+		return nil
+	}
+	if r.connector == nil {
+		return errNotJoined
+	}
+	log.Infof("id=%v EnablePublishing, join again with a publisher", r.uid)
+	config := make(JoinConfig)
+	for k, v := range r.joinConfig {
+		if k != "NoPublish" {
+			config[k] = v
+		}
+	}
+	if err := r.rejoin(config); err != nil {
+		log.Errorf("id=%v EnablePublishing err=%v", r.uid, err)
+		return err
+	}
+	r.noPublish = false
+	return nil
+}
+
+// rejoin join the session again with config on a new signal stream and new transports
+func (r *RTC) rejoin(config JoinConfig) error {
+	// dial first, the old session is kept if it fails
+	stream, err := r.connector.Signal(r)
+	if err != nil {
+		return err
+	}
+	signaller := &auditSignaller{Signaller: stream, rtc: r}
+	r.Lock()
+	r.signalLock.Lock()
+	old := r.signaller
+	r.signaller = signaller
+	r.signalLock.Unlock()
+	r.Unlock()
+	if err := old.CloseSend(); err != nil {
+		log.Errorf("id=%v close old signal err=%v", r.uid, err)
+	}
+
+	// create the new transports first, swap them in under the lock their readers take
+	pub, sub := NewTransport(Target_PUBLISHER, r), NewTransport(Target_SUBSCRIBER, r)
+	if pub == nil || sub == nil {
+		go r.close(errInvalidPC)
+		return errInvalidPC
+	}
+	r.transportLock.Lock()
+	oldPub, oldSub := r.pub, r.sub
+	r.pub, r.sub = pub, sub
+	r.transportLock.Unlock()
+	oldPub.pc.Close()
+	oldSub.pc.Close()
+	r.setState(ClientStateJoining)
+	// subscribed again by the track events of the new peer
+	r.Lock()
+	r.subscribed = make(map[string]bool)
+	r.Unlock()
+	// sent when the new api datachannel opens
+	r.callLock.Lock()
+	r.apiQueue = r.apiQueue[:0]
+	for _, call := range r.calls {
+		r.apiQueue = append(r.apiQueue, call)
+	}
+	r.callLock.Unlock()
+
+	// the datachannels of the old pub transport are closed, create them again for the join offer
+	if err := r.RestoreDataChannels(r.DataChannelState()); err != nil {
+		go r.close(err)
+		return err
+	}
+
+	go r.handleSignal(signaller)
+	if err := r.join(r.sid, r.uid, &config); err != nil {
+		// the old peer is gone
+		go r.close(err)
+		return err
+	}
+	return nil
+}
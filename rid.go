@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/pion/interceptor"
+	log "github.com/pion/ion-log"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+)
+
+// setTrackRID send a published video track as a simulcast layer with rid,
+// must be set before the track is negotiated
+func (r *RTC) setTrackRID(trackID, rid string) {
+	r.ridLock.Lock()
+	defer r.ridLock.Unlock()
+	r.trackRIDs[trackID] = rid
+}
+
+// streamRID get the mid and rid of a published track, empty rid if not sent with rid
+func (r *RTC) streamRID(trackID string) (string, string) {
+	pub := r.GetPubTransport()
+	r.ridLock.Lock()
+	rid := r.trackRIDs[trackID]
+	r.ridLock.Unlock()
+	if rid == "" {
+		return "", ""
+	}
+	for _, t := range pub.pc.GetTransceivers() {
+		if track := t.Sender().Track(); track != nil && track.ID() == trackID {
+			return t.Mid(), rid
+		}
+	}
+	return "", ""
+}
+
+// ridSDP rewrite the media sections of tracks with rid as simulcast(a=rid, a=simulcast, no a=ssrc),
+// so sfu identifies the stream by mid/rid header extensions, the local description is not changed
+func (r *RTC) ridSDP(offer string) string {
+	pub := r.GetPubTransport()
+	mids := make(map[string]string)
+	r.ridLock.Lock()
+	empty := len(r.trackRIDs) == 0
+	r.ridLock.Unlock()
+	if empty {
+		return offer
+	}
+	for _, t := range pub.pc.GetTransceivers() {
+		if track := t.Sender().Track(); track != nil {
+			if mid, rid := r.streamRID(track.ID()); rid != "" && mid != "" {
+				mids[mid] = rid
+			}
+		}
+	}
+	if len(mids) == 0 {
+		return offer
+	}
+
+	desc := &sdp.SessionDescription{}
+	if err := desc.Unmarshal([]byte(offer)); err != nil {
+		log.Errorf("id=%v ridSDP err=%v", r.uid, err)
+		return offer
+	}
+	for _, m := range desc.MediaDescriptions {
+		mid, _ := m.Attribute(sdp.AttrKeyMID)
+		rid, ok := mids[mid]
+		if !ok {
+			continue
+		}
+		attributes := m.Attributes[:0]
+		for _, a := range m.Attributes {
+			if a.Key == sdp.AttrKeySSRC || a.Key == sdp.AttrKeySSRCGroup {
+				continue
+			}
+			attributes = append(attributes, a)
+		}
+		m.Attributes = attributes
+		m.WithValueAttribute("rid", fmt.Sprintf("%s send", rid))
+		m.WithValueAttribute("simulcast", fmt.Sprintf("send %s", rid))
+	}
+	marshalled, err := desc.Marshal()
+	if err != nil {
+		log.Errorf("id=%v ridSDP err=%v", r.uid, err)
+		return offer
+	}
+	return string(marshalled)
+}
+
+// ridInterceptorFactory build ridInterceptor for the pub transport
+type ridInterceptorFactory struct {
+	rtc *RTC
+}
+
+// NewInterceptor implements interceptor.Factory
+func (f *ridInterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &ridInterceptor{rtc: f.rtc}, nil
+}
+
+// ridInterceptor write mid/rid header extensions to the packets of tracks sent with rid
+type ridInterceptor struct {
+	interceptor.NoOp
+	rtc *RTC
+}
+
+// BindLocalStream implements interceptor.Interceptor
+func (i *ridInterceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	mid, rid := i.rtc.streamRID(info.ID)
+	if rid == "" {
+		return writer
+	}
+	var midID, ridID uint8
+	for _, ext := range info.RTPHeaderExtensions {
+		switch ext.URI {
+		case sdp.SDESMidURI:
+			midID = uint8(ext.ID)
+		case sdp.SDESRTPStreamIDURI:
+			ridID = uint8(ext.ID)
+		}
+	}
+	if midID == 0 || ridID == 0 {
+		log.Warnf("id=%v track %v rid %v, mid/rid header extension not negotiated", i.rtc.uid, info.ID, rid)
+		return writer
+	}
+	log.Infof("id=%v track %v send with mid=%v rid=%v", i.rtc.uid, info.ID, mid, rid)
+
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		if err := header.SetExtension(midID, []byte(mid)); err != nil {
+			return 0, err
+		}
+		if err := header.SetExtension(ridID, []byte(rid)); err != nil {
+			return 0, err
+		}
+		return writer.Write(header, payload, attributes)
+	})
+}
@@ -573,7 +573,12 @@ func (c *Room) Name() string {
 func (c *Room) Connect() {
 	var err error
 	c.ctx, c.cancel = context.WithCancel(context.Background())
-	c.ctx = metadata.NewOutgoingContext(c.ctx, c.connector.Metadata)
+	md, err := c.connector.signalMetadata()
+	if err != nil {
+		log.Errorf("error: %v", err)
+		return
+	}
+	c.ctx = metadata.NewOutgoingContext(c.ctx, md)
 	c.roomServiceClient = room.NewRoomServiceClient(c.connector.grpcConn)
 	c.roomSignalClient = room.NewRoomSignalClient(c.connector.grpcConn)
 	c.roomSignalStream, err = c.roomSignalClient.Signal(c.ctx)
@@ -4,12 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/pion/interceptor"
 	log "github.com/pion/ion-log"
 	"github.com/pion/ion/proto/rtc"
 	"github.com/pion/webrtc/v3"
@@ -19,15 +22,42 @@ import (
 
 const (
 	API_CHANNEL = "ion-sfu"
+
+	// retries of api datachannel sends and the first backoff, doubled each retry
+	apiRetries      = 3
+	apiRetryBackoff = 50 * time.Millisecond
+	// max bytes queued on api datachannel before sending more
+	apiMaxBuffered = 1 << 20
+
+	// backoff between read retries of a track, doubled each error
+	readRetryBackoff    = 10 * time.Millisecond
+	maxReadRetryBackoff = time.Second
+	// consecutive read errors to give up a track
+	defaultReadErrorLimit = 10
+	// signaling requests of a kind waiting a reply, for SignalLatency
+	maxSignalPending = 32
 )
 
 //Call dc api
 type Call struct {
-	StreamID string `json:"streamId"`
-	Video    string `json:"video"`
-	Audio    bool   `json:"audio"`
+	StreamID  string   `json:"streamId"`
+	Video     string   `json:"video"`
+	Audio     bool     `json:"audio"`
+	Framerate string   `json:"framerate,omitempty"`
+	Layers    []string `json:"layers,omitempty"`
 }
 
+var (
+	// simulcast rid to ion-sfu video quality
+	ridQuality = map[string]string{
+		"f": "high",
+		"h": "medium",
+		"q": "low",
+	}
+	// temporal layer to ion-sfu framerate
+	temporalFramerate = []string{"low", "medium", "high"}
+)
+
 type TrackInfo struct {
 	Id        string
 	Kind      string
@@ -41,6 +71,18 @@ type TrackInfo struct {
 	Width     uint32
 	Height    uint32
 	FrameRate uint32
+	// negotiated codec mime type(e.g. video/VP9), not signaled by sfu, set when the track arrives,
+	// see RemoteTrackInfo
+	Codec string
+}
+
+// LocalTrack a published track and its sender/transceiver
+type LocalTrack struct {
+	ID          string
+	StreamID    string
+	Kind        webrtc.RTPCodecType
+	Sender      *webrtc.RTPSender
+	Transceiver *webrtc.RTPTransceiver
 }
 
 type Subscription struct {
@@ -100,13 +142,64 @@ var (
 // WebRTCTransportConfig represents configuration options
 type WebRTCTransportConfig struct {
 	// if set, only this codec will be registered. leave unset to register all codecs.
-	VideoMime     string
+	VideoMime string
+	// ICETransportPolicy relay forces TURN on both transports, or set ICE.RelayOnly
 	Configuration webrtc.Configuration
 	Setting       webrtc.SettingEngine
+	// ICE-lite, host acceleration and nomination toggles applied to Setting
+	ICE ICEConfig
+	// opus fmtp of the pub sdp, leave nil for default(useinbandfec), see AudioConfig for what it affects
+	Audio *AudioConfig
+	// if set, used by both transports instead of the built-in codecs, register extra codecs(e.g. H265) here.
+	MediaEngine *webrtc.MediaEngine
+	// if set, interceptors(nack, twcc, custom rtp processing) registered on both transports
+	Interceptors *interceptor.Registry
+	// custom rtp header extension uris registered for audio and video on both transports,
+	// ignored with MediaEngine(register them there), values are sent by SetHeaderExtension
+	HeaderExtensions []string
+	// if > 0, wait ICE gathering up to this timeout before sending offer/answer, so the sdp carries the candidates
+	// gathered(for signaling without trickle, or to bound initial gathering), 0 means no wait, trickle only
+	GatherTimeout time.Duration
 }
 
 type RTCConfig struct {
 	WebRTC WebRTCTransportConfig `mapstructure:"webrtc"`
+	// if > 0, the client subscribes tracks itself(joins with NoAutoSubscribe) and stops
+	// auto subscribing when the limit is reached, see OnSubscriptionLimit
+	MaxSubscriptions int `mapstructure:"maxsubscriptions"`
+	// no track is subscribed unless by Subscribe/SubscribeFromEvent, joins with NoAutoSubscribe and
+	// the default track event handler does nothing
+	NoAutoSubscribe bool `mapstructure:"noautosubscribe"`
+	// simulcast layer(f/h/q) of the video auto subscribed, the sfu default(high) if empty
+	DefaultSubscribeLayer string `mapstructure:"defaultsubscribelayer"`
+	// data only client, only datachannels are negotiated, no media is published or subscribed
+	DataOnly bool `mapstructure:"dataonly"`
+	// if > 0, negotiation triggers(Publish/UnPublish...) in this window are coalesced into a single offer
+	NegotiationDebounce time.Duration `mapstructure:"negotiationdebounce"`
+	// min interval of PLIs per ssrc, requests in the interval are coalesced, 500ms if 0, < 0 means no limit
+	PLIInterval time.Duration `mapstructure:"pliinterval"`
+	// a subscribed video track is frozen if no rtp arrives in this threshold, 2s if 0, < 0 means no detection
+	FreezeThreshold time.Duration `mapstructure:"freezethreshold"`
+	// publish files once instead of looping, see OnPublishEnded
+	NoFileLoop bool `mapstructure:"nofileloop"`
+	// interval of OnStats, 1s if 0
+	StatsInterval time.Duration `mapstructure:"statsinterval"`
+	// if > 0, fire OnSubscribeTimeout when no rtp of a subscribed track arrives in this timeout
+	SubscribeTimeout time.Duration `mapstructure:"subscribetimeout"`
+	// unsubscribe the track on SubscribeTimeout
+	UnsubscribeOnTimeout bool `mapstructure:"unsubscribeontimeout"`
+	// sub loss fraction(0~1) sampled every StatsInterval to fire OnPacketLoss, 0.05 if 0
+	PacketLossThreshold float64 `mapstructure:"packetlossthreshold"`
+	// send api commands as text messages instead of binary, for sfu builds that ignore binary control messages
+	APIText bool `mapstructure:"apitext"`
+	// the default read loop gives up a track after this many consecutive read errors, 10 if 0, < 0 means never
+	ReadErrorLimit int `mapstructure:"readerrorlimit"`
+	// how Subscribe/SubscribeFromEvent reach the sfu, SubscribeSignal if empty
+	SubscribeMode SubscribeMode `mapstructure:"subscribemode"`
+	// negotiate audio/red, video/red and video/ulpfec on the sub transport(ignored with MediaEngine), OnTrack handlers
+	// get RED payloads then(see REDReceiver), SubscribeRTP and EnableCapture of the default read loop get the
+	// unwrapped media
+	ReceiveRED bool `mapstructure:"receivered"`
 }
 
 // Signaller sends and receives signalling messages with peers.
@@ -128,34 +221,176 @@ type RTC struct {
 	config *RTCConfig
 
 	uid string
-	pub *Transport
-	sub *Transport
-
-	//export to user
-	OnTrack       func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver)
-	OnDataChannel func(*webrtc.DataChannel)
-	OnError       func(error)
-	OnTrackEvent  func(event TrackEvent)
-	OnSpeaker     func(event []string)
-
-	producer *WebMProducer
-	recvByte int
-	notify   chan struct{}
+	// replaced when joining again(see EnablePublishing), read by GetPubTransport/GetSubTransport
+	pub           *Transport
+	sub           *Transport
+	transportLock sync.RWMutex
+
+	// exported callbacks, set before Join or by the setters(SetOnTrack...) after
+	Callbacks
+
+	// b=AS of the pub offer in kbps, atomic, see SetMaxPublishBandwidth
+	maxPubKbps int32
+	// joined with NoPublish, until EnablePublishing
+	noPublish bool
+	noPubLock sync.Mutex
+	// the config of the last join request
+	joinConfig JoinConfig
+	// joined with NoAutoSubscribe, tracks are subscribed by Subscribe only
+	noAutoSub bool
+	// lifecycle state, see State
+	state     ClientState
+	stateLock sync.Mutex
+
+	producer   *WebMProducer
+	recvByte   int
+	captureDir string
+	// recv bytes by simulcast layer
+	layerByte map[string]int
+	layerLock sync.Mutex
+	notify    chan struct{}
 
 	//cache datachannel api operation before dr.OnOpen
 	apiQueue []Call
+	// last api cmd by stream id, for ResendSubscriptions
+	calls    map[string]Call
+	callLock sync.Mutex
+	// SpeakerFollowsVideo state
+	follow     *speakerFollow
+	followLock sync.Mutex
+
+	// last known ssrc of local tracks
+	localSSRC map[string]uint32
+	// send times of the requests waiting a reply by kind, replies come in order
+	signalSendTime map[string][]time.Time
+	// smoothed request to reply time, see SignalLatency
+	signalLatency time.Duration
+	rttLock       sync.Mutex
+
+	// smoothed audio level by stream id
+	audioLevels map[string]float64
+	levelLock   sync.Mutex
+
+	// pending debounced negotiation
+	negTimer *time.Timer
+	negLock  sync.Mutex
+
+	// stop of EnableAdaptiveLayers
+	adaptiveStop context.CancelFunc
+	adaptiveLock sync.Mutex
+
+	// codec order by kind, see SetPreferredCodecs
+	codecPrefs map[webrtc.RTPCodecType][]string
+	codecLock  sync.Mutex
+
+	// stop of the default read loops by track id
+	readers    map[string]chan struct{}
+	readerLock sync.Mutex
+
+	// SubscribeRTP channels by track id
+	rtpChans map[string]*rtpChan
+	rtpLock  sync.Mutex
+
+	// last sent and pending PLI by ssrc
+	pliSent    map[uint32]time.Time
+	pliPending map[uint32]bool
+	pliLock    sync.Mutex
+
+	// rid of published video tracks sent as simulcast layer
+	trackRIDs map[string]string
+	// layers paused by SetSendLayerActive by track id
+	pausedLayers map[string]pausedLayer
+	ridLock      sync.Mutex
+
+	// custom header extension values of published tracks by track id and uri
+	headerExts map[string]map[string][]byte
+	headerLock sync.Mutex
+
+	// scalability modes of tracks by PublishSVC
+	svcModes map[string]string
+	svcLock  sync.Mutex
+
+	// labels of published streams
+	streamLabels map[string]string
+	labelLock    sync.Mutex
+
+	// custom datachannels by label
+	dataChannels map[string]*webrtc.DataChannel
+	// options of the datachannels by CreateDataChannel in the order of creation, kept after close for DataChannelState
+	dcConfigs []DataChannelConfig
+	dcLock    sync.Mutex
+	// message id of SendDataChunked
+	chunkSeq  uint32
+	chunkLock sync.Mutex
+
+	// senders of published tracks, for PublishState
+	pubSenders map[*webrtc.RTPSender]bool
+	pubLock    sync.Mutex
+
+	// remote tracks by id from track events, for SubscribeAPI
+	remoteTracks map[string]*TrackInfo
+	// participant uid of remote tracks by track id
+	remoteUIDs map[string]string
+	remoteLock sync.Mutex
+
+	// tracks subscribed by autoSubscribe
+	subscribed map[string]bool
+	// waiters of WaitForTrackRemoved by track id
+	removeWaiters map[string][]chan struct{}
+	// SubscribeTimeout timers by track id
+	subTimers    map[string]*time.Timer
+	subTimerLock sync.Mutex
+	// waiters of WaitForTrack by stream id
+	trackWaiters map[string][]chan *webrtc.TrackRemote
 
 	signaller Signaller
+	// guard replacing the signaller, sends are guarded by the client lock
+	signalLock sync.Mutex
+	// set by NewRTC, for PublishIsolated
+	connector *Connector
+	sid       string
+
+	// clients of PublishIsolated and their uids
+	isolated     map[*RTC]string
+	isolatedSeq  int
+	isolatedLock sync.Mutex
 
 	ctx        context.Context
 	cancel     context.CancelFunc
 	handleOnce sync.Once
+	closeOnce  sync.Once
+	// guard the callbacks with setters
+	callbackLock sync.RWMutex
 	sync.Mutex
 }
 
 func withConfig(config ...RTCConfig) *RTC {
 	r := &RTC{
-		notify: make(chan struct{}),
+		notify:         make(chan struct{}),
+		localSSRC:      make(map[string]uint32),
+		removeWaiters:  make(map[string][]chan struct{}),
+		trackWaiters:   make(map[string][]chan *webrtc.TrackRemote),
+		subTimers:      make(map[string]*time.Timer),
+		isolated:       make(map[*RTC]string),
+		layerByte:      make(map[string]int),
+		subscribed:     make(map[string]bool),
+		signalSendTime: make(map[string][]time.Time),
+		dataChannels:   make(map[string]*webrtc.DataChannel),
+		calls:          make(map[string]Call),
+		readers:        make(map[string]chan struct{}),
+		codecPrefs:     make(map[webrtc.RTPCodecType][]string),
+		streamLabels:   make(map[string]string),
+		audioLevels:    make(map[string]float64),
+		pliSent:        make(map[uint32]time.Time),
+		pliPending:     make(map[uint32]bool),
+		rtpChans:       make(map[string]*rtpChan),
+		trackRIDs:      make(map[string]string),
+		pausedLayers:   make(map[string]pausedLayer),
+		headerExts:     make(map[string]map[string][]byte),
+		svcModes:       make(map[string]string),
+		remoteTracks:   make(map[string]*TrackInfo),
+		remoteUIDs:     make(map[string]string),
+		pubSenders:     make(map[*webrtc.RTPSender]bool),
 	}
 	r.ctx, r.cancel = context.WithCancel(context.Background())
 
@@ -168,113 +403,213 @@ func withConfig(config ...RTCConfig) *RTC {
 
 // NewRTC creates an RTC using the default GRPC signaller
 func NewRTC(connector *Connector, config ...RTCConfig) (*RTC, error) {
+	config = connector.inheritConfig(config...)
+	if len(config) > 0 {
+		if err := config[0].WebRTC.Validate(); err != nil {
+			log.Errorf("config error: %v", err)
+			return nil, err
+		}
+	}
 	r := withConfig(config...)
+	r.connector = connector
 	signaller, err := connector.Signal(r)
+	if err != nil {
+		// no signal stream to receive from
+		log.Errorf("signal error: %v", err)
+		r.cancel()
+		return nil, err
+	}
 	r.start(signaller)
-	return r, err
+	return r, nil
 }
 
-// NewRTCWithSignaller creates an RTC with a specified signaller
+// NewRTCWithSignaller creates an RTC with a specified signaller. If the config is invalid the client is not started
+// and returned closed(see State), use NewRTCWithSignallerChecked to get the error
 func NewRTCWithSignaller(signaller Signaller, config ...RTCConfig) *RTC {
+	r, err := NewRTCWithSignallerChecked(signaller, config...)
+	if err != nil {
+		r = withConfig(config...)
+		r.close(err)
+	}
+	return r
+}
+
+// NewRTCWithSignallerChecked creates an RTC with a specified signaller, or return the error of an invalid config
+func NewRTCWithSignallerChecked(signaller Signaller, config ...RTCConfig) (*RTC, error) {
+	if len(config) > 0 {
+		if err := config[0].WebRTC.Validate(); err != nil {
+			log.Errorf("config error: %v", err)
+			return nil, err
+		}
+	}
 	r := withConfig(config...)
 	r.start(signaller)
-	return r
+	return r, nil
+}
+
+// clientBinder is implemented by signallers needing the client, e.g. WHIPSignaller for ICE gathering
+type clientBinder interface {
+	bind(r *RTC)
 }
 
 func (r *RTC) start(signaller Signaller) {
-	r.signaller = signaller
+	if b, ok := signaller.(clientBinder); ok {
+		b.bind(r)
+	}
+	r.signaller = &auditSignaller{Signaller: signaller, rtc: r}
 
 	if !r.Connected() {
 		r.Connect()
 	}
 	r.pub = NewTransport(Target_PUBLISHER, r)
-	r.sub = NewTransport(Target_PUBLISHER, r)
+	r.sub = NewTransport(Target_SUBSCRIBER, r)
+	go r.statsLoop()
+}
+
+// PrepareConnection start ICE gathering of the pub transport before Join, so the join offer already has
+// candidates, call it early(e.g. on a lobby screen) to reduce join latency
+func (r *RTC) PrepareConnection() error {
+	pub := r.GetPubTransport()
+	if pub == nil {
+		return errInvalidPC
+	}
+	offer, err := pub.pc.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	if err = pub.pc.SetLocalDescription(offer); err != nil {
+		log.Errorf("id=%v PrepareConnection err=%v", r.uid, err)
+		return err
+	}
+	log.Infof("id=%v PrepareConnection gathering", r.uid)
+	pub.prepared = true
+	return nil
 }
 
 // Join client join a session
-func (r *RTC) Join(sid, uid string, config ...*JoinConfig) error {
+func (r *RTC) Join(sid, uid string, config ...*JoinConfig) (err error) {
+	pub, sub := r.GetPubTransport(), r.GetSubTransport()
 	log.Infof("[C=>S] sid=%v uid=%v", sid, uid)
+	if err := r.checkState(ClientStateNew); err != nil {
+		return err
+	}
+	// NewTransport returns nil if the pc failed to be created
+	if pub == nil || sub == nil {
+		return errInvalidPC
+	}
+	r.setState(ClientStateJoining)
+	defer func() {
+		if err != nil {
+			r.compareAndSetState(ClientStateJoining, ClientStateNew)
+		}
+	}()
 	if uid == "" {
 		uid = RandomKey(6)
 	}
+	r.noPubLock.Lock()
+	r.noPublish = len(config) > 0 && (*config[0])["NoPublish"] == "true"
+	r.noPubLock.Unlock()
+	return r.join(sid, uid, config...)
+}
+
+// join set up the transports and send the join request, the state is Joining
+func (r *RTC) join(sid, uid string, config ...*JoinConfig) error {
+	pub, sub := r.GetPubTransport(), r.GetSubTransport()
 	r.uid = uid
-	r.sub.pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+	r.sid = sid
+	sub.pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
 		log.Infof("[S=>C] got track streamId=%v kind=%v ssrc=%v ", track.StreamID(), track.Kind(), track.SSRC())
+		r.trackArrived(track)
+		r.setRemoteCodec(track)
 
 		// user define
-		if r.OnTrack != nil {
-			r.OnTrack(track, receiver)
+		callbacks := r.callbacks()
+		if onTrack := callbacks.OnTrack; onTrack != nil {
+			onTrack(track, receiver)
+		} else if onRemoteTrack := callbacks.OnRemoteTrack; onRemoteTrack != nil {
+			onRemoteTrack(r.remoteUID(track), track, receiver)
 		} else {
-			//for read and calc
-			b := make([]byte, 1500)
-			for {
-				select {
-				case <-r.notify:
-					return
-				default:
-					n, _, err := track.Read(b)
-					if err != nil {
-						if err == io.EOF {
-							log.Errorf("id=%v track.ReadRTP err=%v", r.uid, err)
-							return
-						}
-						log.Errorf("id=%v Error reading track rtp %s", r.uid, err)
-						continue
-					}
-					r.recvByte += n
-				}
-			}
+			r.readTrack(track, receiver)
 		}
 	})
 
-	r.sub.pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+	sub.pc.OnDataChannel(func(dc *webrtc.DataChannel) {
 		log.Debugf("[S=>C] id=%v [r.sub.pc.OnDataChannel] got dc %v", r.uid, dc.Label())
 		if dc.Label() == API_CHANNEL {
 			log.Debugf("%v got dc %v", r.uid, dc.Label())
-			r.sub.api = dc
+			reopened := sub.api != nil
+			sub.api = dc
+			sub.api.OnMessage(r.onAPIMessage)
 			// send cmd after open
-			r.sub.api.OnOpen(func() {
-				if len(r.apiQueue) > 0 {
-					for _, cmd := range r.apiQueue {
-						log.Debugf("%v r.sub.api.OnOpen send cmd=%v", r.uid, cmd)
-						marshalled, err := json.Marshal(cmd)
-						if err != nil {
-							continue
-						}
-						err = r.sub.api.Send(marshalled)
-						if err != nil {
-							log.Errorf("id=%v err=%v", r.uid, err)
-						}
-						time.Sleep(time.Millisecond * 10)
+			sub.api.OnOpen(func() {
+				if reopened {
+					log.Infof("id=%v api datachannel reopened, resend subscriptions", r.uid)
+					if err := r.ResendSubscriptions(); err != nil {
+						log.Errorf("id=%v ResendSubscriptions err=%v", r.uid, err)
 					}
-					r.apiQueue = []Call{}
+				}
+				r.flushAPIQueue()
+				if onAPIReady := r.callbacks().OnAPIReady; onAPIReady != nil {
+					onAPIReady()
 				}
 			})
 			return
 		}
 		log.Debugf("%v got dc %v", r.uid, dc.Label())
-		if r.OnDataChannel != nil {
-			r.OnDataChannel(dc)
+		r.addDataChannel(dc)
+		if onDataChannel := r.callbacks().OnDataChannel; onDataChannel != nil {
+			onDataChannel(dc)
 		}
 	})
 
-	r.sub.pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+	sub.pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
 		if state >= webrtc.ICEConnectionStateDisconnected {
 			log.Infof("ICEConnectionStateDisconnected %v", state)
 
 		}
+		switch state {
+		case webrtc.ICEConnectionStateDisconnected:
+			r.compareAndSetState(ClientStateJoined, ClientStateReconnecting)
+		case webrtc.ICEConnectionStateConnected, webrtc.ICEConnectionStateCompleted:
+			r.compareAndSetState(ClientStateReconnecting, ClientStateJoined)
+		}
+		// no ice restart, the client is dead
+		if state == webrtc.ICEConnectionStateFailed {
+			go r.close(errICEFailed)
+		}
 	})
 
-	offer, err := r.pub.pc.CreateOffer(nil)
+	offer, err := pub.pc.CreateOffer(nil)
 	if err != nil {
 		return err
 	}
 
-	err = r.pub.pc.SetLocalDescription(offer)
+	err = pub.pc.SetLocalDescription(offer)
 	if err != nil {
 		return err
 	}
 
+	// subscribe by client when limit subscriptions or disabled, and never for data only client
+	if r.config.MaxSubscriptions > 0 || r.config.DataOnly || r.config.NoAutoSubscribe {
+		if len(config) == 0 {
+			config = append(config, NewJoinConfig())
+		}
+		config[0].SetNoAutoSubscribe()
+	}
+	r.noAutoSub = len(config) > 0 && (*config[0])["NoAutoSubscribe"] == "true"
+	// for EnablePublishing
+	r.joinConfig = make(JoinConfig)
+	if len(config) > 0 {
+		for k, v := range *config[0] {
+			r.joinConfig[k] = v
+		}
+	}
+
+	// candidates gathered since PrepareConnection
+	if desc := pub.pc.LocalDescription(); pub.prepared && desc != nil {
+		offer = *desc
+	}
+	offer = pub.gatheredDescription(offer)
 	if len(config) > 0 {
 		err = r.SendJoin(sid, r.uid, offer, *config[0])
 	} else {
@@ -288,32 +623,218 @@ func (r *RTC) Join(sid, uid string, config ...*JoinConfig) error {
 	return err
 }
 
+// EnableCapture dump received rtp of each track to dir in rtpdump format, only for the default read loop.
+// RED is dumped unwrapped. call it before Join to capture all tracks
+func (r *RTC) EnableCapture(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	r.captureDir = dir
+	return nil
+}
+
+// readTrack is the default read loop when OnTrack is not set, read and calc the track
+func (r *RTC) readTrack(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+	//dump rtp if capture enabled
+	var dump *rtpDumpWriter
+	if dir := r.captureDir; dir != "" {
+		name := fmt.Sprintf("%s_%s_%d.rtpdump", track.StreamID(), track.Kind(), track.SSRC())
+		var err error
+		if dump, err = newRTPDumpWriter(filepath.Join(dir, name)); err != nil {
+			log.Errorf("id=%v newRTPDumpWriter err=%v", r.uid, err)
+		} else {
+			defer dump.Close()
+		}
+	}
+	//simulcast layer is keyed by rid, or ssrc if no rid
+	layer := track.RID()
+	if layer == "" {
+		layer = fmt.Sprintf("%d", track.SSRC())
+	}
+	//audio level extension id, 0 if not negotiated
+	var audioLevelID uint8
+	if track.Kind() == webrtc.RTPCodecTypeAudio {
+		audioLevelID = audioLevelExtensionID(receiver)
+	}
+	//unwrap RED for SubscribeRTP and the dump, nil if not RED
+	red := NewREDReceiver(track, receiver)
+	//unix nano of the last rtp, for freeze detection
+	var lastRTP int64
+	if track.Kind() == webrtc.RTPCodecTypeVideo {
+		lastRTP = time.Now().UnixNano()
+		done := make(chan struct{})
+		defer close(done)
+		go r.watchFreeze(track.ID(), &lastRTP, done)
+	}
+	r.openRTP(track.ID())
+	defer r.closeRTP(track.ID())
+	stop := r.addReader(track.ID())
+	defer r.removeReader(track.ID(), stop)
+	//for read and calc
+	b := make([]byte, 1500)
+	// consecutive read errors and the backoff before next read
+	readErrors := 0
+	backoff := readRetryBackoff
+	for {
+		select {
+		case <-r.notify:
+			return
+		case <-stop:
+			log.Infof("id=%v stop reading track %v", r.uid, track.ID())
+			return
+		default:
+			n, _, err := track.Read(b)
+			if err != nil {
+				if err == io.EOF {
+					// the normal end of track
+					log.Infof("id=%v track ended trackId=%v", r.uid, track.ID())
+					if onTrackEnded := r.callbacks().OnTrackEnded; onTrackEnded != nil {
+						onTrackEnded(track.ID())
+					}
+					return
+				}
+				readErrors++
+				log.Errorf("id=%v Error reading track rtp %s", r.uid, err)
+				if limit := r.readErrorLimit(); limit > 0 && readErrors >= limit {
+					err = fmt.Errorf("%w: trackId=%v %v errors, last err=%v", errReadTrack, track.ID(), readErrors, err)
+					log.Errorf("id=%v %v", r.uid, err)
+					r.onError(err)
+					return
+				}
+				time.Sleep(backoff)
+				if backoff *= 2; backoff > maxReadRetryBackoff {
+					backoff = maxReadRetryBackoff
+				}
+				continue
+			}
+			readErrors, backoff = 0, readRetryBackoff
+			r.recvByte += n
+			if lastRTP != 0 {
+				atomic.StoreInt64(&lastRTP, time.Now().UnixNano())
+			}
+			r.layerLock.Lock()
+			r.layerByte[layer] += n
+			r.layerLock.Unlock()
+			if audioLevelID != 0 {
+				r.updateAudioLevel(track.StreamID(), audioLevelID, b[:n])
+			}
+			if red == nil {
+				if dump != nil {
+					if err := dump.WriteRTP(b[:n]); err != nil {
+						log.Errorf("id=%v dump.WriteRTP err=%v", r.uid, err)
+					}
+				}
+				r.pushRTP(track.ID(), b[:n])
+				continue
+			}
+			// unwrapped even without a consumer to keep the recovery history
+			pkts := r.unwrapRED(red, b[:n])
+			if dump != nil {
+				for _, pkt := range pkts {
+					raw, err := pkt.Marshal()
+					if err == nil {
+						err = dump.WriteRTP(raw)
+					}
+					if err != nil {
+						log.Errorf("id=%v dump.WriteRTP err=%v", r.uid, err)
+					}
+				}
+			}
+			r.pushPackets(track.ID(), pkts)
+		}
+	}
+}
+
+func (r *RTC) readErrorLimit() int {
+	if r.config.ReadErrorLimit == 0 {
+		return defaultReadErrorLimit
+	}
+	return r.config.ReadErrorLimit
+}
+
+// addReader register a default read loop, return its stop chan
+func (r *RTC) addReader(trackID string) chan struct{} {
+	r.readerLock.Lock()
+	defer r.readerLock.Unlock()
+	stop := make(chan struct{})
+	r.readers[trackID] = stop
+	return stop
+}
+
+// removeReader unregister an exited read loop
+func (r *RTC) removeReader(trackID string, stop chan struct{}) {
+	r.readerLock.Lock()
+	defer r.readerLock.Unlock()
+	if r.readers[trackID] == stop {
+		delete(r.readers, trackID)
+	}
+}
+
+// StopReading stop the default read loop(OnTrack not set) of a remote track, e.g. after unsubscribed,
+// the loop exits after the read in progress returns
+func (r *RTC) StopReading(trackID string) error {
+	r.readerLock.Lock()
+	defer r.readerLock.Unlock()
+	stop, ok := r.readers[trackID]
+	if !ok {
+		return errInvalidTrack
+	}
+	close(stop)
+	delete(r.readers, trackID)
+	return nil
+}
+
 // GetPubStats get pub stats
 func (r *RTC) GetPubStats() webrtc.StatsReport {
-	return r.pub.pc.GetStats()
+	pub := r.GetPubTransport()
+	if pub == nil {
+		return webrtc.StatsReport{}
+	}
+	return pub.pc.GetStats()
 }
 
 // GetSubStats get sub stats
 func (r *RTC) GetSubStats() webrtc.StatsReport {
-	return r.sub.pc.GetStats()
+	sub := r.GetSubTransport()
+	if sub == nil {
+		return webrtc.StatsReport{}
+	}
+	return sub.pc.GetStats()
 }
 
+// GetPubTransport get the pub transport, nil if failed to create it
 func (r *RTC) GetPubTransport() *Transport {
+	r.transportLock.RLock()
+	defer r.transportLock.RUnlock()
 	return r.pub
 }
 
+// GetSubTransport get the sub transport, nil if failed to create it
 func (r *RTC) GetSubTransport() *Transport {
+	r.transportLock.RLock()
+	defer r.transportLock.RUnlock()
 	return r.sub
 }
 
 // Publish local tracks
 func (r *RTC) Publish(tracks ...webrtc.TrackLocal) ([]*webrtc.RTPSender, error) {
+	pub := r.GetPubTransport()
+	if err := r.canPublish(); err != nil {
+		return nil, err
+	}
+	for _, t := range tracks {
+		if err := r.checkTrackCodec(t); err != nil {
+			log.Errorf("id=%v Publish err=%v", r.uid, err)
+			return nil, err
+		}
+	}
 	var rtpSenders []*webrtc.RTPSender
 	for _, t := range tracks {
-		if rtpSender, err := r.pub.GetPeerConnection().AddTrack(t); err != nil {
+		if rtpSender, err := pub.GetPeerConnection().AddTrack(t); err != nil {
 			log.Errorf("AddTrack error: %v", err)
 			return rtpSenders, err
 		} else {
+			r.trackPublished(rtpSender)
 			rtpSenders = append(rtpSenders, rtpSender)
 		}
 
@@ -322,31 +843,232 @@ func (r *RTC) Publish(tracks ...webrtc.TrackLocal) ([]*webrtc.RTPSender, error)
 	return rtpSenders, nil
 }
 
+// PublishWithDirection publish tracks with transceivers of direction, sendonly for one way broadcast
+// so sfu doesn't allocate receiving resources, Publish uses sendrecv
+func (r *RTC) PublishWithDirection(direction webrtc.RTPTransceiverDirection, tracks ...webrtc.TrackLocal) ([]*webrtc.RTPSender, error) {
+	pub := r.GetPubTransport()
+	if direction != webrtc.RTPTransceiverDirectionSendonly && direction != webrtc.RTPTransceiverDirectionSendrecv {
+		return nil, errInvalidParams
+	}
+	if err := r.canPublish(); err != nil {
+		return nil, err
+	}
+	for _, t := range tracks {
+		if err := r.checkTrackCodec(t); err != nil {
+			log.Errorf("id=%v Publish err=%v", r.uid, err)
+			return nil, err
+		}
+	}
+	var rtpSenders []*webrtc.RTPSender
+	for _, t := range tracks {
+		transceiver, err := pub.pc.AddTransceiverFromTrack(t, webrtc.RTPTransceiverInit{Direction: direction})
+		if err != nil {
+			log.Errorf("AddTransceiverFromTrack error: %v", err)
+			return rtpSenders, err
+		}
+		r.trackPublished(transceiver.Sender())
+		rtpSenders = append(rtpSenders, transceiver.Sender())
+	}
+	r.onNegotiationNeeded()
+	return rtpSenders, nil
+}
+
+// streamTrack override the stream id(and track id if set) of a local track
+type streamTrack struct {
+	webrtc.TrackLocal
+	streamID string
+	trackID  string
+}
+
+// StreamID is the msid stream id sent in sdp
+func (t *streamTrack) StreamID() string {
+	return t.streamID
+}
+
+// ID is the msid track id sent in sdp
+func (t *streamTrack) ID() string {
+	if t.trackID == "" {
+		return t.TrackLocal.ID()
+	}
+	return t.trackID
+}
+
+// PublishStream publish tracks under one stream id, so remote peers group them(e.g. for A/V sync)
+func (r *RTC) PublishStream(streamID string, tracks ...webrtc.TrackLocal) ([]*webrtc.RTPSender, error) {
+	if streamID == "" {
+		return nil, errInvalidParams
+	}
+	streamTracks := make([]webrtc.TrackLocal, 0, len(tracks))
+	for _, t := range tracks {
+		if t.StreamID() == streamID {
+			streamTracks = append(streamTracks, t)
+			continue
+		}
+		streamTracks = append(streamTracks, &streamTrack{TrackLocal: t, streamID: streamID})
+	}
+	return r.Publish(streamTracks...)
+}
+
+// PublishOptions identifiers of a published track
+type PublishOptions struct {
+	// msid stream id, the track's own if empty
+	StreamID string
+	// msid track id, the track's own if empty
+	TrackID string
+}
+
+// PublishWithOptions publish a track with a stable msid(e.g. kept across reconnects for recording/analytics),
+// the ssrc is assigned by the sender and can't be chosen, it's reported by OnLocalSSRCChange
+func (r *RTC) PublishWithOptions(track webrtc.TrackLocal, options PublishOptions) (*webrtc.RTPSender, error) {
+	if track == nil {
+		return nil, errInvalidParams
+	}
+	if options.StreamID != "" || options.TrackID != "" {
+		streamID := options.StreamID
+		if streamID == "" {
+			streamID = track.StreamID()
+		}
+		track = &streamTrack{TrackLocal: track, streamID: streamID, trackID: options.TrackID}
+	}
+	senders, err := r.Publish(track)
+	if err != nil {
+		return nil, err
+	}
+	return senders[0], nil
+}
+
+// canPublish check if media can be published in current mode
+func (r *RTC) canPublish() error {
+	pub := r.GetPubTransport()
+	// tracks may be published before Join(see ClientStateNew), but not without the pub transport or after Close
+	if pub == nil {
+		return ErrNotJoined
+	}
+	if state := r.State(); state == ClientStateClosed {
+		return fmt.Errorf("%w: %v", errInvalidState, state)
+	}
+	if r.config.DataOnly {
+		return errDataOnly
+	}
+	r.noPubLock.Lock()
+	noPublish := r.noPublish
+	r.noPubLock.Unlock()
+	if noPublish {
+		return errNoPublish
+	}
+	return nil
+}
+
+// SetStreamLabel tag a published stream with a readable label(e.g. "camera", "screen"),
+// which is sent to sfu with the offer and shows in the remote TrackInfo.Label
+func (r *RTC) SetStreamLabel(streamID, label string) {
+	pub := r.GetPubTransport()
+	r.labelLock.Lock()
+	r.streamLabels[streamID] = label
+	r.labelLock.Unlock()
+
+	if pub == nil || pub.pc.CurrentRemoteDescription() == nil {
+		return
+	}
+	for _, sender := range pub.pc.GetSenders() {
+		if t := sender.Track(); t != nil && t.StreamID() == streamID {
+			// renegotiate to update the label
+			r.onNegotiationNeeded()
+			return
+		}
+	}
+}
+
+// localTrackInfos describe the published tracks for sfu
+func (r *RTC) localTrackInfos() []*rtc.TrackInfo {
+	pub := r.GetPubTransport()
+	r.labelLock.Lock()
+	defer r.labelLock.Unlock()
+	var infos []*rtc.TrackInfo
+	for _, sender := range pub.pc.GetSenders() {
+		t := sender.Track()
+		if t == nil {
+			continue
+		}
+		infos = append(infos, &rtc.TrackInfo{
+			Id:       t.ID(),
+			Kind:     t.Kind().String(),
+			StreamId: t.StreamID(),
+			Label:    r.streamLabels[t.StreamID()],
+		})
+	}
+	return infos
+}
+
+// LocalTracks return the tracks currently published
+func (r *RTC) LocalTracks() []LocalTrack {
+	pub := r.GetPubTransport()
+	var tracks []LocalTrack
+	if pub == nil {
+		return tracks
+	}
+	for _, tr := range pub.pc.GetTransceivers() {
+		sender := tr.Sender()
+		if sender == nil || sender.Track() == nil {
+			continue
+		}
+		t := sender.Track()
+		tracks = append(tracks, LocalTrack{
+			ID:          t.ID(),
+			StreamID:    t.StreamID(),
+			Kind:        t.Kind(),
+			Sender:      sender,
+			Transceiver: tr,
+		})
+	}
+	return tracks
+}
+
 // UnPublish local tracks by transceivers
 func (r *RTC) UnPublish(senders ...*webrtc.RTPSender) error {
+	pub := r.GetPubTransport()
+	if pub == nil {
+		return ErrNotJoined
+	}
 	for _, s := range senders {
-		if err := r.pub.pc.RemoveTrack(s); err != nil {
+		if err := pub.pc.RemoveTrack(s); err != nil {
 			return err
 		}
+		r.trackUnpublished(s)
 	}
 	r.onNegotiationNeeded()
 	return nil
 }
 
-// CreateDataChannel create a custom datachannel
-func (r *RTC) CreateDataChannel(label string) (*webrtc.DataChannel, error) {
+// CreateDataChannel create a custom datachannel, reliable and ordered if init is not set
+func (r *RTC) CreateDataChannel(label string, init ...*webrtc.DataChannelInit) (*webrtc.DataChannel, error) {
+	pub := r.GetPubTransport()
 	log.Debugf("id=%v CreateDataChannel %v", r.uid, label)
-	return r.pub.pc.CreateDataChannel(label, &webrtc.DataChannelInit{})
+	if pub == nil {
+		return nil, ErrNotJoined
+	}
+	options := &webrtc.DataChannelInit{}
+	if len(init) > 0 && init[0] != nil {
+		options = init[0]
+	}
+	dc, err := pub.pc.CreateDataChannel(label, options)
+	if err != nil {
+		return nil, err
+	}
+	r.setDataChannelConfig(DataChannelConfig{Label: label, Init: options})
+	r.addDataChannel(dc)
+	return dc, nil
 }
 
 // trickle receive candidate from sfu and add to pc
 func (r *RTC) trickle(candidate webrtc.ICECandidateInit, target Target) {
+	pub, sub := r.GetPubTransport(), r.GetSubTransport()
 	log.Debugf("[S=>C] id=%v candidate=%v target=%v", r.uid, candidate, target)
 	var t *Transport
 	if target == Target_SUBSCRIBER {
-		t = r.sub
+		t = sub
 	} else {
-		t = r.pub
+		t = pub
 	}
 
 	if t.pc.CurrentRemoteDescription() == nil {
@@ -360,213 +1082,750 @@ func (r *RTC) trickle(candidate webrtc.ICECandidateInit, target Target) {
 
 }
 
-// negotiate sub negotiate
-func (r *RTC) negotiate(sdp webrtc.SessionDescription) error {
-	log.Debugf("[S=>C] id=%v Negotiate sdp=%v", r.uid, sdp)
-	// 1.sub set remote sdp
-	err := r.sub.pc.SetRemoteDescription(sdp)
+// negotiate sub negotiate
+func (r *RTC) negotiate(sdp webrtc.SessionDescription) error {
+	return r.answerOffer(r.GetSubTransport(), sdp)
+}
+
+// negotiatePub answer the pub renegotiation initiated by sfu
+func (r *RTC) negotiatePub(sdp webrtc.SessionDescription) error {
+	return r.answerOffer(r.GetPubTransport(), sdp)
+}
+
+// answerOffer answer an offer from sfu on transport t
+func (r *RTC) answerOffer(t *Transport, sdp webrtc.SessionDescription) (err error) {
+	log.Debugf("[S=>C] id=%v Negotiate target=%v sdp=%v", r.uid, t.role, sdp)
+	defer r.recoverSDP(&err)
+	if err = validateRemoteSDP(sdp); err != nil {
+		log.Errorf("id=%v Negotiate target=%v err=%v", r.uid, t.role, err)
+		return err
+	}
+	// 1.set remote sdp
+	err = t.pc.SetRemoteDescription(sdp)
+	if err != nil {
+		log.Errorf("id=%v Negotiate t.pc.SetRemoteDescription err=%v", r.uid, err)
+		return err
+	}
+
+	// 2. safe to send candiate to sfu after join ok
+	if len(t.SendCandidates) > 0 {
+		for _, cand := range t.SendCandidates {
+			log.Debugf("[C=>S] id=%v send SendCandidates cand=%v", r.uid, cand)
+			r.SendTrickle(cand, t.role)
+		}
+		t.SendCandidates = []*webrtc.ICECandidate{}
+	}
+
+	// 3. safe to add candidate after SetRemoteDescription
+	if len(t.RecvCandidates) > 0 {
+		for _, candidate := range t.RecvCandidates {
+			log.Debugf("id=%v t.pc.AddICECandidate candidate=%v", r.uid, candidate)
+			_ = t.pc.AddICECandidate(candidate)
+		}
+		t.RecvCandidates = []webrtc.ICECandidateInit{}
+	}
+
+	// 4. create answer after add ice candidate, with the preferred codecs
+	r.applyCodecPreferences(t)
+	answer, err := t.pc.CreateAnswer(nil)
+	if err != nil {
+		log.Errorf("id=%v err=%v", r.uid, err)
+		return err
+	}
+
+	// 5. set local sdp(answer)
+	err = t.pc.SetLocalDescription(answer)
+	if err != nil {
+		log.Errorf("id=%v err=%v", r.uid, err)
+		return err
+	}
+
+	r.verifyMediaSections(t)
+
+	// 6. send answer to sfu
+	err = r.sendAnswer(t.gatheredDescription(answer), t.role)
+	if err != nil {
+		log.Errorf("id=%v err=%v", r.uid, err)
+		return err
+	}
+	return err
+}
+
+// onNegotiationNeeded will be called when add/remove track, but never trigger, call by hand
+func (r *RTC) onNegotiationNeeded() {
+	// tracks and datachannels added before Join are in the join offer
+	if !r.joinOffered() {
+		log.Debugf("id=%v not joined, negotiate by join offer", r.uid)
+		return
+	}
+	d := r.config.NegotiationDebounce
+	if d <= 0 {
+		r.sendPubOffer()
+		return
+	}
+
+	// coalesce the triggers in the window into one offer
+	r.negLock.Lock()
+	defer r.negLock.Unlock()
+	if r.negTimer != nil {
+		return
+	}
+	r.negTimer = time.AfterFunc(d, func() {
+		r.negLock.Lock()
+		r.negTimer = nil
+		r.negLock.Unlock()
+		r.sendPubOffer()
+	})
+}
+
+// sendPubOffer create and send a pub offer carrying all the current changes
+func (r *RTC) sendPubOffer() {
+	pub := r.GetPubTransport()
+	// 1. pub create offer
+	offer, err := pub.pc.CreateOffer(nil)
+	if err != nil {
+		log.Errorf("id=%v err=%v", r.uid, err)
+	}
+
+	// 2. pub set local sdp(offer)
+	err = pub.pc.SetLocalDescription(offer)
+	if err != nil {
+		log.Errorf("id=%v err=%v", r.uid, err)
+	}
+
+	//3. send offer to sfu
+	err = r.SendOffer(pub.gatheredDescription(offer))
+	if err != nil {
+		log.Errorf("id=%v err=%v", r.uid, err)
+	}
+}
+
+// selectRemote select remote video/audio
+func (r *RTC) selectRemote(streamId, video string, audio bool) error {
+	if err := r.checkJoined(); err != nil {
+		return err
+	}
+	log.Debugf("id=%v streamId=%v video=%v audio=%v", r.uid, streamId, video, audio)
+	return r.sendCall(Call{
+		StreamID: streamId,
+		Video:    video,
+		Audio:    audio,
+	})
+}
+
+// SelectLayer select the simulcast layer of a remote stream by rid(f/h/q) and temporal layer(0-2),
+// always by api datachannel, see SubscribeMode
+func (r *RTC) SelectLayer(streamID, rid string, temporalLayer int) error {
+	video, ok := ridQuality[rid]
+	if !ok || temporalLayer < 0 || temporalLayer >= len(temporalFramerate) {
+		return errInvalidParams
+	}
+	if err := r.checkJoined(); err != nil {
+		return err
+	}
+	log.Debugf("id=%v streamId=%v rid=%v temporalLayer=%v", r.uid, streamID, rid, temporalLayer)
+	return r.sendCall(Call{
+		StreamID:  streamID,
+		Video:     video,
+		Audio:     true,
+		Framerate: temporalFramerate[temporalLayer],
+		Layers:    []string{rid},
+	})
+}
+
+// SubscribeAudioOnly receive only the audio of a remote stream(e.g. the tile is off-screen),
+// sfu stops forwarding its video until SubscribeVideo
+func (r *RTC) SubscribeAudioOnly(streamID string) error {
+	if streamID == "" {
+		return errInvalidParams
+	}
+	return r.selectRemote(streamID, "none", true)
+}
+
+// SubscribeVideo receive the audio and the video of a remote stream with simulcast layer(f/h/q)
+func (r *RTC) SubscribeVideo(streamID, layer string) error {
+	video, ok := ridQuality[layer]
+	if streamID == "" || !ok {
+		return errInvalidParams
+	}
+	return r.selectRemote(streamID, video, true)
+}
+
+// flushAPIQueue send the cmds cached before api datachannel open
+func (r *RTC) flushAPIQueue() {
+	if len(r.apiQueue) == 0 {
+		return
+	}
+	for _, cmd := range r.apiQueue {
+		log.Debugf("[C=>S] id=%v r.sub.api.Send cmd=%v", r.uid, cmd)
+		marshalled, err := json.Marshal(cmd)
+		if err != nil {
+			continue
+		}
+		err = r.sendAPI(marshalled)
+		if err != nil {
+			log.Errorf("id=%v err=%v", r.uid, err)
+		} else {
+			r.layerSwitched(cmd)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	r.apiQueue = []Call{}
+}
+
+// ResendSubscriptions send the last api cmd of every stream again, e.g. after reconnect the sfu
+// lost the selected qualities, it's called when the api datachannel is opened again
+func (r *RTC) ResendSubscriptions() error {
+	if err := r.checkJoined(); err != nil {
+		return err
+	}
+	r.callLock.Lock()
+	calls := make([]Call, 0, len(r.calls))
+	for _, call := range r.calls {
+		calls = append(calls, call)
+	}
+	r.callLock.Unlock()
+
+	for _, call := range calls {
+		if err := r.sendCall(call); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendAPI send a marshalled cmd on the api datachannel, as text if APIText
+// retry on transient failures(e.g. sctp buffer full) with backoff, then fire OnError
+func (r *RTC) sendAPI(data []byte) error {
+	sub := r.GetSubTransport()
+	var err error
+	backoff := apiRetryBackoff
+	for i := 0; i <= apiRetries; i++ {
+		if i > 0 {
+			log.Warnf("id=%v api send err=%v, retry in %v", r.uid, err, backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if sub.api.ReadyState() != webrtc.DataChannelStateOpen {
+			err = errInvalidDataChannel
+			break
+		}
+		// backpressure, wait the queued to drain
+		if sub.api.BufferedAmount() > apiMaxBuffered {
+			err = errBufferFull
+			continue
+		}
+		if r.config.APIText {
+			err = sub.api.SendText(string(data))
+		} else {
+			err = sub.api.Send(data)
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	err = fmt.Errorf("%w: %v", errAPISend, err)
+	r.onError(err)
+	return err
+}
+
+// sendCall send a call by api datachannel, cache it when dc not ready
+func (r *RTC) sendCall(call Call) error {
+	sub := r.GetSubTransport()
+	if sub == nil {
+		return ErrNotJoined
+	}
+	r.callLock.Lock()
+	r.calls[call.StreamID] = call
+	r.callLock.Unlock()
+
+	// cache cmd when dc not ready
+	if sub.api == nil || sub.api.ReadyState() != webrtc.DataChannelStateOpen {
+		log.Debugf("id=%v append to r.apiQueue call=%v", r.uid, call)
+		r.apiQueue = append(r.apiQueue, call)
+		return nil
+	}
+
+	// send cached cmd
+	r.flushAPIQueue()
+
+	// send this cmd
+	log.Debugf("[C=>S] id=%v r.sub.api.Send call=%v", r.uid, call)
+	marshalled, err := json.Marshal(call)
+	if err != nil {
+		return err
+	}
+	err = r.sendAPI(marshalled)
+	if err != nil {
+		log.Errorf("id=%v err=%v", r.uid, err)
+		return err
+	}
+	r.layerSwitched(call)
+	return nil
+}
+
+// layerSwitched request a keyframe after switching video layer, avoid artifacts of delta frames
+func (r *RTC) layerSwitched(call Call) {
+	if call.Video == "" || call.Video == "none" {
+		return
+	}
+	if err := r.RequestKeyFrame(call.StreamID); err != nil {
+		log.Debugf("id=%v RequestKeyFrame streamId=%v err=%v", r.uid, call.StreamID, err)
+	}
+}
+
+// RequestKeyFrame send PLI for the video tracks of a remote stream
+func (r *RTC) RequestKeyFrame(streamID string) error {
+	sub := r.GetSubTransport()
+	if sub == nil {
+		return ErrNotJoined
+	}
+	var ssrcs []uint32
+	for _, receiver := range sub.pc.GetReceivers() {
+		for _, t := range receiver.Tracks() {
+			if t.StreamID() == streamID && t.Kind() == webrtc.RTPCodecTypeVideo {
+				ssrcs = append(ssrcs, uint32(t.SSRC()))
+			}
+		}
+	}
+	if len(ssrcs) == 0 {
+		return errInvalidTrack
+	}
+	return r.writePLI(ssrcs)
+}
+
+// PublishWebm publish a webm producer
+func (r *RTC) PublishFile(file string, video, audio bool) error {
+	trackIDs, err := r.publishFile(file, video, audio)
+	if err != nil {
+		return err
+	}
+	r.startFile(trackIDs)
+	return nil
+}
+
+// PublishFileRID publish the video of a webm file as a single simulcast layer with rid(f/h/q),
+// so sfu routes it as simulcast, e.g. for testing simulcast with a reproducible source
+func (r *RTC) PublishFileRID(file, rid string, audio bool) error {
+	if _, ok := ridQuality[rid]; !ok {
+		return errInvalidParams
+	}
+	trackIDs, err := r.publishFile(file, true, audio)
+	if err != nil {
+		return err
+	}
+	// video is the first
+	r.setTrackRID(trackIDs[0], rid)
+	r.startFile(trackIDs)
+	return nil
+}
+
+// startFile start the producer of PublishFile and negotiate
+func (r *RTC) startFile(trackIDs []string) {
+	if r.config.NoFileLoop {
+		r.producer.SetLoop(false)
+		r.producer.SetOnEnded(func() {
+			r.publishEnded(trackIDs)
+		})
+	}
+	r.producer.Start()
+	//trigger by hand
+	r.onNegotiationNeeded()
+}
+
+// PublishPlaylist publish webm files one by one through the same tracks, so subscribers see one continuous stream,
+// files must have the same codecs, the playlist loops unless NoFileLoop
+func (r *RTC) PublishPlaylist(files []string, video, audio bool) error {
+	if len(files) == 0 {
+		return errInvalidParams
+	}
+	// check codecs up front, not when switching
+	var mime string
+	for i, file := range files {
+		if !FileExist(file) {
+			return os.ErrNotExist
+		}
+		if filepath.Ext(file) != ".webm" {
+			return errInvalidFile
+		}
+		fileMime, hasAudio, err := webmCodecs(file)
+		if err != nil {
+			return err
+		}
+		if video && (fileMime == "" || (i > 0 && fileMime != mime)) {
+			return fmt.Errorf("%w: %v video codec %v not match %v", errInvalidFile, file, fileMime, mime)
+		}
+		if audio && !hasAudio {
+			return fmt.Errorf("%w: %v not audio track", errInvalidFile, file)
+		}
+		mime = fileMime
+	}
+
+	trackIDs, err := r.publishFile(files[0], video, audio)
+	if err != nil {
+		return err
+	}
+	r.producer.SetLoop(false)
+	next := 1
+	r.producer.SetOnEnded(func() {
+		if next == len(files) {
+			if r.config.NoFileLoop {
+				r.publishEnded(trackIDs)
+				return
+			}
+			next = 0
+		}
+		log.Infof("id=%v playlist next file=%v", r.uid, files[next])
+		if err := r.producer.SwapFile(files[next]); err != nil {
+			r.producer.fail(err)
+			return
+		}
+		next++
+	})
+	r.producer.Start()
+	//trigger by hand
+	r.onNegotiationNeeded()
+	return nil
+}
+
+// onProducerError fire OnError for a failed file producer, the app decides to retry, switch files or unpublish
+func (r *RTC) onProducerError(err error) {
+	if r.ctx.Err() != nil {
+		// closing
+		return
+	}
+	log.Errorf("id=%v file producer err=%v", r.uid, err)
+	r.onError(fmt.Errorf("%w: %v", errProducer, err))
+}
+
+// publishEnded fire OnPublishEnded for the tracks of the publishing file
+func (r *RTC) publishEnded(trackIDs []string) {
+	onPublishEnded := r.callbacks().OnPublishEnded
+	if onPublishEnded == nil {
+		return
+	}
+	for _, trackID := range trackIDs {
+		onPublishEnded(trackID)
+	}
+}
+
+// publishFile create the producer of a file and add its tracks, not started
+func (r *RTC) publishFile(file string, video, audio bool) ([]string, error) {
+	if err := r.canPublish(); err != nil {
+		return nil, err
+	}
+	if !FileExist(file) {
+		return nil, os.ErrNotExist
+	}
+	var producer *WebMProducer
+	ext := filepath.Ext(file)
+	switch ext {
+	case ".webm":
+		producer = NewWebMProducer(file, 0)
+	default:
+		return nil, errInvalidFile
+	}
+	if producer == nil {
+		return nil, errInvalidFile
+	}
+	return r.publishProducer(producer, video, audio)
+}
+
+// PublishReader publish webm read from r like PublishFile, e.g. streamed from object storage without local disk,
+// seeking and looping work as r is a ReadSeeker, wrap forward-only data(e.g. bytes.NewReader) to use it
+func (r *RTC) PublishReader(reader io.ReadSeeker, video, audio bool) error {
+	if err := r.canPublish(); err != nil {
+		return err
+	}
+	producer := NewWebMProducerFromReader(reader, 0)
+	if producer == nil {
+		return errInvalidFile
+	}
+	trackIDs, err := r.publishProducer(producer, video, audio)
 	if err != nil {
-		log.Errorf("id=%v Negotiate r.sub.pc.SetRemoteDescription err=%v", r.uid, err)
 		return err
 	}
+	r.startFile(trackIDs)
+	return nil
+}
 
-	// 2. safe to send candiate to sfu after join ok
-	if len(r.sub.SendCandidates) > 0 {
-		for _, cand := range r.sub.SendCandidates {
-			log.Debugf("[C=>S] id=%v send sub.SendCandidates r.uid, r.rtc.trickle cand=%v", r.uid, cand)
-			r.SendTrickle(cand, Target_SUBSCRIBER)
+// publishProducer add the tracks of producer as r.producer, not started. It is atomic: on failure
+// the tracks already added are removed and the producer is released, r.producer is kept
+func (r *RTC) publishProducer(producer *WebMProducer, video, audio bool) ([]string, error) {
+	pub := r.GetPubTransport()
+	// get all tracks first, a file without the wanted track adds nothing
+	var tracks []*webrtc.TrackLocalStaticSample
+	if video {
+		videoTrack, err := producer.GetVideoTrack()
+		if err != nil {
+			log.Debugf("error: %v", err)
+			producer.release()
+			return nil, err
 		}
-		r.sub.SendCandidates = []*webrtc.ICECandidate{}
+		tracks = append(tracks, videoTrack)
 	}
-
-	// 3. safe to add candidate after SetRemoteDescription
-	if len(r.sub.RecvCandidates) > 0 {
-		for _, candidate := range r.sub.RecvCandidates {
-			log.Debugf("id=%v r.sub.pc.AddICECandidate candidate=%v", r.uid, candidate)
-			_ = r.sub.pc.AddICECandidate(candidate)
+	if audio {
+		audioTrack, err := producer.GetAudioTrack()
+		if err != nil {
+			log.Debugf("error: %v", err)
+			producer.release()
+			return nil, err
 		}
-		r.sub.RecvCandidates = []webrtc.ICECandidateInit{}
+		tracks = append(tracks, audioTrack)
 	}
 
-	// 4. create answer after add ice candidate
-	answer, err := r.sub.pc.CreateAnswer(nil)
-	if err != nil {
-		log.Errorf("id=%v err=%v", r.uid, err)
-		return err
+	var senders []*webrtc.RTPSender
+	var trackIDs []string
+	for _, track := range tracks {
+		sender, err := pub.pc.AddTrack(track)
+		if err != nil {
+			log.Errorf("id=%v publish file track %v err=%v, roll back", r.uid, track.ID(), err)
+			for _, s := range senders {
+				if err := pub.pc.RemoveTrack(s); err != nil {
+					log.Errorf("id=%v roll back RemoveTrack err=%v", r.uid, err)
+				}
+				r.trackUnpublished(s)
+			}
+			producer.release()
+			return nil, err
+		}
+		r.trackPublished(sender)
+		senders = append(senders, sender)
+		trackIDs = append(trackIDs, track.ID())
 	}
 
-	// 5. set local sdp(answer)
-	err = r.sub.pc.SetLocalDescription(answer)
-	if err != nil {
-		log.Errorf("id=%v err=%v", r.uid, err)
-		return err
+	producer.SetOnError(r.onProducerError)
+	producer.estimator = r.congestionBitrate
+	r.producer = producer
+	for _, sender := range senders {
+		go readSenderRTCP(sender)
 	}
+	return trackIDs, nil
+}
 
-	// 6. send answer to sfu
-	err = r.SendAnswer(answer)
-	if err != nil {
-		log.Errorf("id=%v err=%v", r.uid, err)
-		return err
+// PauseFile pause the publishing file, the tracks keep alive and subscribers freeze on the last frame
+func (r *RTC) PauseFile() error {
+	if r.producer == nil {
+		return errNotPublishingFile
 	}
-	return err
+	log.Infof("id=%v PauseFile", r.uid)
+	r.producer.Pause(true)
+	return nil
 }
 
-// onNegotiationNeeded will be called when add/remove track, but never trigger, call by hand
-func (r *RTC) onNegotiationNeeded() {
-	// 1. pub create offer
-	offer, err := r.pub.pc.CreateOffer(nil)
-	if err != nil {
-		log.Errorf("id=%v err=%v", r.uid, err)
+// ResumeFile resume the publishing file paused by PauseFile
+func (r *RTC) ResumeFile() error {
+	if r.producer == nil {
+		return errNotPublishingFile
 	}
+	log.Infof("id=%v ResumeFile", r.uid)
+	r.producer.Pause(false)
+	return nil
+}
 
-	// 2. pub set local sdp(offer)
-	err = r.pub.pc.SetLocalDescription(offer)
-	if err != nil {
-		log.Errorf("id=%v err=%v", r.uid, err)
+// PlaybackPosition return the position and the duration of the publishing file
+func (r *RTC) PlaybackPosition() (pos, duration time.Duration) {
+	if r.producer == nil {
+		return 0, 0
 	}
+	return r.producer.Position()
+}
 
-	//3. send offer to sfu
-	err = r.SendOffer(offer)
-	if err != nil {
-		log.Errorf("id=%v err=%v", r.uid, err)
+// Seek seek the publishing file to the nearest keyframe before pos
+func (r *RTC) Seek(pos time.Duration) error {
+	if r.producer == nil {
+		return errNotPublishingFile
 	}
+	log.Infof("id=%v Seek %v", r.uid, pos)
+	return r.producer.Seek(pos)
 }
 
-// selectRemote select remote video/audio
-func (r *RTC) selectRemote(streamId, video string, audio bool) error {
-	log.Debugf("id=%v streamId=%v video=%v audio=%v", r.uid, streamId, video, audio)
-	call := Call{
-		StreamID: streamId,
-		Video:    video,
-		Audio:    audio,
+// SwapFile replace the publishing webm file without renegotiation, the new file must have the same codecs
+func (r *RTC) SwapFile(file string) error {
+	if r.producer == nil {
+		return errNotPublishingFile
 	}
-
-	// cache cmd when dc not ready
-	if r.sub.api == nil || r.sub.api.ReadyState() != webrtc.DataChannelStateOpen {
-		log.Debugf("id=%v append to r.apiQueue call=%v", r.uid, call)
-		r.apiQueue = append(r.apiQueue, call)
-		return nil
+	if !FileExist(file) {
+		return os.ErrNotExist
 	}
-
-	// send cached cmd
-	if len(r.apiQueue) > 0 {
-		for _, cmd := range r.apiQueue {
-			log.Debugf("[C=>S] id=%v r.sub.api.Send cmd=%v", r.uid, cmd)
-			marshalled, err := json.Marshal(cmd)
-			if err != nil {
-				continue
-			}
-			err = r.sub.api.Send(marshalled)
-			if err != nil {
-				log.Errorf("error: %v", err)
-			}
-			time.Sleep(time.Millisecond * 10)
-		}
-		r.apiQueue = []Call{}
+	if filepath.Ext(file) != ".webm" {
+		return errInvalidFile
 	}
+	log.Infof("id=%v SwapFile %v", r.uid, file)
+	return r.producer.SwapFile(file)
+}
 
-	// send this cmd
-	log.Debugf("[C=>S] id=%v r.sub.api.Send call=%v", r.uid, call)
-	marshalled, err := json.Marshal(call)
-	if err != nil {
-		return err
-	}
-	err = r.sub.api.Send(marshalled)
-	if err != nil {
-		log.Errorf("id=%v err=%v", r.uid, err)
+func (r *RTC) trackEvent(event TrackEvent) {
+	onTrackEvent := r.callbacks().OnTrackEvent
+	if onTrackEvent == nil {
+		r.autoSubscribe(event)
+		return
 	}
-	return err
+	onTrackEvent(event)
 }
 
-// PublishWebm publish a webm producer
-func (r *RTC) PublishFile(file string, video, audio bool) error {
-	if !FileExist(file) {
-		return os.ErrNotExist
+// autoSubscribe is the default track event handler, subscribe new tracks until MaxSubscriptions is reached
+func (r *RTC) autoSubscribe(event TrackEvent) {
+	if r.config.DataOnly || r.config.NoAutoSubscribe {
+		return
 	}
-	ext := filepath.Ext(file)
-	switch ext {
-	case ".webm":
-		r.producer = NewWebMProducer(file, 0)
-	default:
-		return errInvalidFile
+	if r.config.MaxSubscriptions <= 0 {
+		log.Debugf("id=%v subscriptions managed by sfu, ignore event=%+v", r.uid, event)
+		r.selectDefaultLayer(event)
+		return
 	}
-	if video {
-		videoTrack, err := r.producer.GetVideoTrack()
-		if err != nil {
-			log.Debugf("error: %v", err)
-			return err
-		}
-		_, err = r.pub.pc.AddTrack(videoTrack)
-		if err != nil {
-			log.Debugf("error: %v", err)
-			return err
+
+	r.Lock()
+	if event.State == TrackEvent_REMOVE {
+		for _, t := range event.Tracks {
+			delete(r.subscribed, t.Id)
 		}
+		r.Unlock()
+		return
 	}
-	if audio {
-		audioTrack, err := r.producer.GetAudioTrack()
-		if err != nil {
-			log.Debugf("error: %v", err)
-			return err
+	var infos []*Subscription
+	for _, t := range event.Tracks {
+		if !r.subscribed[t.Id] {
+			layer := t.Layer
+			if r.config.DefaultSubscribeLayer != "" && t.Kind == webrtc.RTPCodecTypeVideo.String() {
+				layer = r.config.DefaultSubscribeLayer
+			}
+			infos = append(infos, &Subscription{
+				TrackId:   t.Id,
+				Mute:      t.Muted,
+				Subscribe: true,
+				Layer:     layer,
+			})
 		}
-		_, err = r.pub.pc.AddTrack(audioTrack)
-		if err != nil {
-			log.Debugf("error: %v", err)
-			return err
+	}
+	if len(infos) == 0 {
+		r.Unlock()
+		return
+	}
+	if len(r.subscribed)+len(infos) > r.config.MaxSubscriptions {
+		r.Unlock()
+		log.Infof("id=%v max subscriptions %v reached, skip event=%+v", r.uid, r.config.MaxSubscriptions, event)
+		if onSubscriptionLimit := r.callbacks().OnSubscriptionLimit; onSubscriptionLimit != nil {
+			onSubscriptionLimit(event)
 		}
+		return
+	}
+	for _, info := range infos {
+		r.subscribed[info.TrackId] = true
+	}
+	r.Unlock()
+
+	if err := r.Subscribe(infos); err != nil {
+		log.Errorf("id=%v Subscribe err=%v", r.uid, err)
 	}
-	r.producer.Start()
-	//trigger by hand
-	r.onNegotiationNeeded()
-	return nil
 }
 
-func (r *RTC) trackEvent(event TrackEvent) {
-	if r.OnTrackEvent == nil {
-		log.Errorf("r.OnTrackEvent == nil")
+// selectDefaultLayer select DefaultSubscribeLayer for the video of new streams subscribed by sfu
+func (r *RTC) selectDefaultLayer(event TrackEvent) {
+	layer := r.config.DefaultSubscribeLayer
+	if layer == "" || event.State != TrackEvent_ADD {
+		return
+	}
+	video, ok := ridQuality[layer]
+	if !ok {
+		log.Errorf("id=%v invalid DefaultSubscribeLayer %v", r.uid, layer)
 		return
 	}
-	r.OnTrackEvent(event)
+	streams := make(map[string]bool)
+	for _, t := range event.Tracks {
+		if t.Kind == webrtc.RTPCodecTypeVideo.String() {
+			streams[t.StreamId] = true
+		}
+	}
+	for streamID := range streams {
+		if err := r.selectRemote(streamID, video, true); err != nil {
+			log.Errorf("id=%v selectRemote streamId=%v err=%v", r.uid, streamID, err)
+		}
+	}
 }
 
 func (r *RTC) speaker(event []string) {
-	if r.OnSpeaker == nil {
-		log.Errorf("r.OnSpeaker == nil")
+	r.followSpeaker(event)
+	onSpeaker := r.callbacks().OnSpeaker
+	if onSpeaker == nil {
+		log.Debugf("r.OnSpeaker == nil")
 		return
 	}
-	r.OnSpeaker(event)
+	onSpeaker(event)
 }
 
 // setRemoteSDP pub SetRemoteDescription and send cadidate to sfu
-func (r *RTC) setRemoteSDP(sdp webrtc.SessionDescription) error {
-	err := r.pub.pc.SetRemoteDescription(sdp)
+func (r *RTC) setRemoteSDP(sdp webrtc.SessionDescription) (err error) {
+	pub := r.GetPubTransport()
+	defer r.recoverSDP(&err)
+	if err = validateRemoteSDP(sdp); err != nil {
+		log.Errorf("id=%v err=%v", r.uid, err)
+		return err
+	}
+	err = pub.pc.SetRemoteDescription(sdp)
 	if err != nil {
 		log.Errorf("id=%v err=%v", r.uid, err)
 		return err
 	}
 
 	// it's safe to add cand now after SetRemoteDescription
-	if len(r.pub.RecvCandidates) > 0 {
-		for _, candidate := range r.pub.RecvCandidates {
+	if len(pub.RecvCandidates) > 0 {
+		for _, candidate := range pub.RecvCandidates {
 			log.Debugf("id=%v r.pub.pc.AddICECandidate candidate=%v", r.uid, candidate)
-			err = r.pub.pc.AddICECandidate(candidate)
+			err = pub.pc.AddICECandidate(candidate)
 			if err != nil {
 				log.Errorf("id=%v r.pub.pc.AddICECandidate err=%v", r.uid, err)
 			}
 		}
-		r.pub.RecvCandidates = []webrtc.ICECandidateInit{}
+		pub.RecvCandidates = []webrtc.ICECandidateInit{}
 	}
 
 	// it's safe to send cand now after join ok
-	if len(r.pub.SendCandidates) > 0 {
-		for _, cand := range r.pub.SendCandidates {
+	if len(pub.SendCandidates) > 0 {
+		for _, cand := range pub.SendCandidates {
 			log.Debugf("id=%v r.rtc.trickle cand=%v", r.uid, cand)
 			r.SendTrickle(cand, Target_PUBLISHER)
 		}
-		r.pub.SendCandidates = []*webrtc.ICECandidate{}
+		pub.SendCandidates = []*webrtc.ICECandidate{}
 	}
+
+	r.verifyMediaSections(pub)
+	r.checkLocalSSRC()
 	return nil
 }
 
+// checkLocalSSRC fire OnLocalSSRCChange for senders whose ssrc changed since last negotiation
+func (r *RTC) checkLocalSSRC() {
+	pub := r.GetPubTransport()
+	r.Lock()
+	changed := make(map[string]uint32)
+	for _, sender := range pub.pc.GetSenders() {
+		track := sender.Track()
+		if track == nil {
+			continue
+		}
+		encodings := sender.GetParameters().Encodings
+		if len(encodings) == 0 {
+			continue
+		}
+		ssrc := uint32(encodings[0].SSRC)
+		if last, ok := r.localSSRC[track.ID()]; !ok || last != ssrc {
+			r.localSSRC[track.ID()] = ssrc
+			changed[track.ID()] = ssrc
+		}
+	}
+	r.Unlock()
+
+	onLocalSSRCChange := r.callbacks().OnLocalSSRCChange
+	if onLocalSSRCChange == nil {
+		return
+	}
+	for id, ssrc := range changed {
+		log.Infof("id=%v local track=%v ssrc=%v", r.uid, id, ssrc)
+		onLocalSSRCChange(id, ssrc)
+	}
+}
+
 // GetBandWidth call this api cyclely
 func (r *RTC) GetBandWidth(cycle int) (int, int) {
 	var recvBW, sendBW int
@@ -579,6 +1838,18 @@ func (r *RTC) GetBandWidth(cycle int) (int, int) {
 	return recvBW, sendBW
 }
 
+// GetLayerBandWidth call this api cyclely, return recv bandwidth(KB/s) by rid, or ssrc for non-simulcast tracks
+func (r *RTC) GetLayerBandWidth(cycle int) map[string]int {
+	r.layerLock.Lock()
+	defer r.layerLock.Unlock()
+	bw := make(map[string]int, len(r.layerByte))
+	for layer, n := range r.layerByte {
+		bw[layer] = n / cycle / 1000
+	}
+	r.layerByte = make(map[string]int)
+	return bw
+}
+
 func (r *RTC) Name() string {
 	return "Room"
 }
@@ -592,25 +1863,51 @@ func (r *RTC) Connected() bool {
 	return r.connected
 }
 
+// UID return the uid used by Join, random if Join with empty uid
+func (r *RTC) UID() string {
+	return r.uid
+}
+
+// SID return the session id used by Join
+func (r *RTC) SID() string {
+	return r.sid
+}
+
 func (r *RTC) onSingalHandleOnce() {
 	// onSingalHandle is wrapped in a once and only started after another public
 	// method is called to ensure the user has the opportunity to register handlers
 	r.handleOnce.Do(func() {
-		err := r.onSingalHandle()
-		if r.OnError != nil {
-			r.OnError(err)
-		}
+		r.signalLock.Lock()
+		signaller := r.signaller
+		r.signalLock.Unlock()
+		r.handleSignal(signaller)
 	})
 }
 
-func (r *RTC) onSingalHandle() error {
+// handleSignal run the receive loop of a signaller, fire OnError when it ends unless replaced by EnablePublishing
+func (r *RTC) handleSignal(signaller Signaller) {
+	err := r.onSingalHandle(signaller)
+	if !r.isSignaller(signaller) {
+		return
+	}
+	r.onError(err)
+}
+
+// isSignaller check if signaller is the current one
+func (r *RTC) isSignaller(signaller Signaller) bool {
+	r.signalLock.Lock()
+	defer r.signalLock.Unlock()
+	return r.signaller == signaller
+}
+
+func (r *RTC) onSingalHandle(signaller Signaller) error {
 	for {
 		//only one goroutine for recving from stream, no need to lock
-		stream, err := r.signaller.Recv()
+		stream, err := signaller.Recv()
 		if err != nil {
 			if err == io.EOF {
 				log.Infof("[%v] WebRTC Transport Closed", r.uid)
-				if err := r.signaller.CloseSend(); err != nil {
+				if err := signaller.CloseSend(); err != nil {
 					log.Errorf("[%v] error sending close: %s", r.uid, err)
 				}
 				return err
@@ -618,26 +1915,32 @@ func (r *RTC) onSingalHandle() error {
 
 			errStatus, _ := status.FromError(err)
 			if errStatus.Code() == codes.Canceled {
-				if err := r.signaller.CloseSend(); err != nil {
+				if err := signaller.CloseSend(); err != nil {
 					log.Errorf("[%v] error sending close: %s", r.uid, err)
 				}
 				return err
 			}
 
 			log.Errorf("[%v] Error receiving RTC response: %v", r.uid, err)
-			if r.OnError != nil {
-				r.OnError(err)
+			if r.isSignaller(signaller) {
+				r.onError(err)
 			}
 			return err
 		}
+		// replies of the stream replaced by EnablePublishing are for the closed transports
+		if !r.isSignaller(signaller) {
+			return nil
+		}
 
 		switch payload := stream.Payload.(type) {
 		case *rtc.Reply_Join:
+			r.signalReplied("join")
 			success := payload.Join.Success
 			err := errors.New(payload.Join.Error.String())
 
 			if !success {
 				log.Errorf("[%v] [join] failed error: %v", r.uid, err)
+				r.compareAndSetState(ClientStateJoining, ClientStateNew)
 				return err
 			}
 			log.Infof("[%v] [join] success", r.uid)
@@ -649,8 +1952,10 @@ func (r *RTC) onSingalHandle() error {
 
 			if err = r.setRemoteSDP(sdp); err != nil {
 				log.Errorf("[%v] [join] error %s", r.uid, err)
+				r.compareAndSetState(ClientStateJoining, ClientStateNew)
 				return err
 			}
+			r.compareAndSetState(ClientStateJoining, ClientStateJoined)
 		case *rtc.Reply_Description:
 			var sdpType webrtc.SDPType
 			if payload.Description.Type == "offer" {
@@ -662,17 +1967,28 @@ func (r *RTC) onSingalHandle() error {
 				SDP:  payload.Description.Sdp,
 				Type: sdpType,
 			}
-			if sdp.Type == webrtc.SDPTypeOffer {
+			if sdp.Type == webrtc.SDPTypeOffer && payload.Description.Target == rtc.Target_PUBLISHER {
+				log.Infof("[%v] [description] got pub offer call s.negotiatePub sdp=%+v", r.uid, sdp)
+				err := r.negotiatePub(sdp)
+				if err != nil {
+					// the transport keeps the last negotiated state, let the app decide to reconnect
+					log.Errorf("error: %v", err)
+					r.onError(err)
+				}
+			} else if sdp.Type == webrtc.SDPTypeOffer {
 				log.Infof("[%v] [description] got offer call s.OnNegotiate sdp=%+v", r.uid, sdp)
 				err := r.negotiate(sdp)
 				if err != nil {
 					log.Errorf("error: %v", err)
+					r.onError(err)
 				}
 			} else if sdp.Type == webrtc.SDPTypeAnswer {
+				r.signalReplied("offer")
 				log.Infof("[%v] [description] got answer call sdp=%+v", r.uid, sdp)
 				err = r.setRemoteSDP(sdp)
 				if err != nil {
 					log.Errorf("[%v] [description] setRemoteSDP err=%s", r.uid, err)
+					r.onError(err)
 				}
 			}
 		case *rtc.Reply_Trickle:
@@ -681,10 +1997,6 @@ func (r *RTC) onSingalHandle() error {
 			log.Infof("[%v] [trickle] type=%v candidate=%v", r.uid, payload.Trickle.Target, candidate)
 			r.trickle(candidate, Target(payload.Trickle.Target))
 		case *rtc.Reply_TrackEvent:
-			if r.OnTrackEvent == nil {
-				log.Errorf("s.OnTrackEvent == nil")
-				continue
-			}
 			var TrackInfos []*TrackInfo
 			for _, v := range payload.TrackEvent.Tracks {
 				TrackInfos = append(TrackInfos, &TrackInfo{
@@ -706,9 +2018,27 @@ func (r *RTC) onSingalHandle() error {
 				Tracks: TrackInfos,
 			}
 
+			r.updateRemoteTracks(trackEvent)
+			// tracks of PublishIsolated are not looped back
+			if r.isIsolatedUID(trackEvent.Uid) {
+				r.dropIsolatedTracks(trackEvent)
+				break
+			}
+			var trackIDs []string
+			for _, t := range trackEvent.Tracks {
+				trackIDs = append(trackIDs, t.Id)
+			}
+			if trackEvent.State == TrackEvent_REMOVE {
+				r.unwatchSubscribe(trackIDs...)
+				r.trackRemoved(trackEvent)
+			} else if trackEvent.State == TrackEvent_ADD && !r.noAutoSub {
+				// subscribed by sfu
+				r.watchSubscribe(trackIDs...)
+			}
 			log.Infof("s.OnTrackEvent trackEvent=%+v", trackEvent)
-			r.OnTrackEvent(trackEvent)
+			r.trackEvent(trackEvent)
 		case *rtc.Reply_Subscription:
+			r.signalReplied("subscription")
 			if !payload.Subscription.Success {
 				log.Errorf("suscription error: %v", payload.Subscription.Error)
 			}
@@ -723,6 +2053,7 @@ func (r *RTC) onSingalHandle() error {
 func (r *RTC) SendJoin(sid string, uid string, offer webrtc.SessionDescription, config map[string]string) error {
 	log.Infof("[C=>S] [%v] sid=%v", r.uid, sid)
 	go r.onSingalHandleOnce()
+	r.signalSent("join")
 	r.Lock()
 	err := r.signaller.Send(
 		&rtc.Request{
@@ -732,9 +2063,10 @@ func (r *RTC) SendJoin(sid string, uid string, offer webrtc.SessionDescription,
 					Uid:    uid,
 					Config: config,
 					Description: &rtc.SessionDescription{
-						Target: rtc.Target_PUBLISHER,
-						Type:   "offer",
-						Sdp:    offer.SDP,
+						Target:     rtc.Target_PUBLISHER,
+						Type:       "offer",
+						Sdp:        r.pubSDP(offer.SDP),
+						TrackInfos: r.localTrackInfos(),
 					},
 				},
 			},
@@ -743,6 +2075,7 @@ func (r *RTC) SendJoin(sid string, uid string, offer webrtc.SessionDescription,
 	r.Unlock()
 	if err != nil {
 		log.Errorf("[C=>S] [%v] err=%v", r.uid, err)
+		r.signalFailed("join")
 	}
 	return err
 }
@@ -775,14 +2108,16 @@ func (r *RTC) SendTrickle(candidate *webrtc.ICECandidate, target Target) {
 func (r *RTC) SendOffer(sdp webrtc.SessionDescription) error {
 	log.Infof("[C=>S] [%v] sdp=%v", r.uid, sdp)
 	go r.onSingalHandleOnce()
+	r.signalSent("offer")
 	r.Lock()
 	err := r.signaller.Send(
 		&rtc.Request{
 			Payload: &rtc.Request_Description{
 				Description: &rtc.SessionDescription{
-					Target: rtc.Target_PUBLISHER,
-					Type:   "offer",
-					Sdp:    sdp.SDP,
+					Target:     rtc.Target_PUBLISHER,
+					Type:       "offer",
+					Sdp:        r.pubSDP(sdp.SDP),
+					TrackInfos: r.localTrackInfos(),
 				},
 			},
 		},
@@ -790,19 +2125,25 @@ func (r *RTC) SendOffer(sdp webrtc.SessionDescription) error {
 	r.Unlock()
 	if err != nil {
 		log.Errorf("[%v] err=%v", r.uid, err)
+		r.signalFailed("offer")
 		return err
 	}
 	return nil
 }
 
+// SendAnswer send the sub answer
 func (r *RTC) SendAnswer(sdp webrtc.SessionDescription) error {
-	log.Infof("[C=>S] [%v] sdp=%v", r.uid, sdp)
+	return r.sendAnswer(sdp, Target_SUBSCRIBER)
+}
+
+func (r *RTC) sendAnswer(sdp webrtc.SessionDescription, target Target) error {
+	log.Infof("[C=>S] [%v] target=%v sdp=%v", r.uid, target, sdp)
 	r.Lock()
 	err := r.signaller.Send(
 		&rtc.Request{
 			Payload: &rtc.Request_Description{
 				Description: &rtc.SessionDescription{
-					Target: rtc.Target_SUBSCRIBER,
+					Target: rtc.Target(target),
 					Type:   "answer",
 					Sdp:    sdp.SDP,
 				},
@@ -817,11 +2158,71 @@ func (r *RTC) SendAnswer(sdp webrtc.SessionDescription) error {
 	return nil
 }
 
+// signalSent queue the send time of a request which expects a reply, the oldest are dropped
+// if sfu doesn't reply
+func (r *RTC) signalSent(kind string) {
+	r.rttLock.Lock()
+	defer r.rttLock.Unlock()
+	sent := append(r.signalSendTime[kind], time.Now())
+	if len(sent) > maxSignalPending {
+		sent = sent[len(sent)-maxSignalPending:]
+	}
+	r.signalSendTime[kind] = sent
+}
+
+// signalFailed drop the send time of a request of kind failed to send
+func (r *RTC) signalFailed(kind string) {
+	r.rttLock.Lock()
+	defer r.rttLock.Unlock()
+	if sent := r.signalSendTime[kind]; len(sent) > 0 {
+		r.signalSendTime[kind] = sent[:len(sent)-1]
+	}
+}
+
+// signalReplied update the smoothed signal latency by the reply of kind, which answers the oldest request
+func (r *RTC) signalReplied(kind string) {
+	r.rttLock.Lock()
+	defer r.rttLock.Unlock()
+	sent := r.signalSendTime[kind]
+	if len(sent) == 0 {
+		return
+	}
+	r.signalSendTime[kind] = sent[1:]
+	latency := time.Since(sent[0])
+	if r.signalLatency == 0 {
+		r.signalLatency = latency
+	} else {
+		r.signalLatency = (r.signalLatency*7 + latency) / 8
+	}
+}
+
+// SignalLatency return the smoothed time from a signaling request(join/offer/subscription) to its reply, 0 if unknown.
+// It is not a network rtt: the ion signaling has no ping/pong, so it includes the sfu processing(e.g. sdp negotiation),
+// compare it with the ICE rtt(GetPubStats) to tell a slow signaling path from slow media
+func (r *RTC) SignalLatency() time.Duration {
+	r.rttLock.Lock()
+	defer r.rttLock.Unlock()
+	return r.signalLatency
+}
+
 // Subscribe to tracks
 func (r *RTC) Subscribe(trackInfos []*Subscription) error {
+	if err := r.checkJoined(); err != nil {
+		return err
+	}
 	if len(trackInfos) == 0 {
 		return errors.New("track id is empty")
 	}
+	for _, t := range trackInfos {
+		if t.Subscribe {
+			r.watchSubscribe(t.TrackId)
+		} else {
+			r.unwatchSubscribe(t.TrackId)
+		}
+	}
+	if r.config.SubscribeMode == SubscribeAPI {
+		return r.subscribeAPI(trackInfos)
+	}
 	var infos []*rtc.Subscription
 	for _, t := range trackInfos {
 		infos = append(infos, &rtc.Subscription{
@@ -833,6 +2234,7 @@ func (r *RTC) Subscribe(trackInfos []*Subscription) error {
 	}
 
 	log.Infof("[C=>S] infos: %v", infos)
+	r.signalSent("subscription")
 	err := r.signaller.Send(
 		&rtc.Request{
 			Payload: &rtc.Request_Subscription{
@@ -842,9 +2244,109 @@ func (r *RTC) Subscribe(trackInfos []*Subscription) error {
 			},
 		},
 	)
+	if err != nil {
+		r.signalFailed("subscription")
+	}
 	return err
 }
 
+// WaitForTrackRemoved block until the sfu signals the removal of trackID, or ctx is done
+func (r *RTC) WaitForTrackRemoved(ctx context.Context, trackID string) error {
+	ch := make(chan struct{})
+	r.Lock()
+	r.removeWaiters[trackID] = append(r.removeWaiters[trackID], ch)
+	r.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		r.Lock()
+		waiters := r.removeWaiters[trackID]
+		for i, w := range waiters {
+			if w == ch {
+				r.removeWaiters[trackID] = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(r.removeWaiters[trackID]) == 0 {
+			delete(r.removeWaiters, trackID)
+		}
+		r.Unlock()
+		return ctx.Err()
+	}
+}
+
+// WaitForTrack block until a track of streamID arrives(or return it if already arrived), or ctx is done
+func (r *RTC) WaitForTrack(ctx context.Context, streamID string) (*webrtc.TrackRemote, error) {
+	sub := r.GetSubTransport()
+	if sub == nil {
+		return nil, ErrNotJoined
+	}
+	// register before checking the arrived, so no track is missed
+	ch := make(chan *webrtc.TrackRemote, 1)
+	r.Lock()
+	r.trackWaiters[streamID] = append(r.trackWaiters[streamID], ch)
+	r.Unlock()
+	defer r.removeTrackWaiter(streamID, ch)
+
+	for _, receiver := range sub.pc.GetReceivers() {
+		for _, t := range receiver.Tracks() {
+			if t.StreamID() == streamID {
+				return t, nil
+			}
+		}
+	}
+
+	select {
+	case track := <-ch:
+		return track, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// removeTrackWaiter unregister a waiter of WaitForTrack
+func (r *RTC) removeTrackWaiter(streamID string, ch chan *webrtc.TrackRemote) {
+	r.Lock()
+	defer r.Unlock()
+	waiters := r.trackWaiters[streamID]
+	for i, w := range waiters {
+		if w == ch {
+			r.trackWaiters[streamID] = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(r.trackWaiters[streamID]) == 0 {
+		delete(r.trackWaiters, streamID)
+	}
+}
+
+// trackArrived wake up the waiters of the track's stream
+func (r *RTC) trackArrived(track *webrtc.TrackRemote) {
+	r.Lock()
+	defer r.Unlock()
+	for _, ch := range r.trackWaiters[track.StreamID()] {
+		select {
+		case ch <- track:
+		default:
+		}
+	}
+	delete(r.trackWaiters, track.StreamID())
+}
+
+// trackRemoved wake up the waiters of removed tracks
+func (r *RTC) trackRemoved(event TrackEvent) {
+	r.Lock()
+	defer r.Unlock()
+	for _, t := range event.Tracks {
+		for _, ch := range r.removeWaiters[t.Id] {
+			close(ch)
+		}
+		delete(r.removeWaiters, t.Id)
+	}
+}
+
 // SubscribeFromEvent will parse event and subscribe what you want
 func (r *RTC) SubscribeFromEvent(event TrackEvent, audio, video bool, layer string) error {
 	log.Infof("event=%+v audio=%v video=%v layer=%v", event, audio, video, layer)
@@ -910,13 +2412,27 @@ func (r *RTC) SubscribeFromEvent(event TrackEvent, audio, video bool, layer stri
 
 // Close client close
 func (r *RTC) Close() {
-	log.Infof("id=%v", r.uid)
-	close(r.notify)
-	if r.pub != nil {
-		r.pub.pc.Close()
-	}
-	if r.sub != nil {
-		r.sub.pc.Close()
-	}
-	r.cancel()
+	r.close(nil)
+}
+
+// close the client once, by user(reason nil) or internally, then fire OnClose
+func (r *RTC) close(reason error) {
+	r.closeOnce.Do(func() {
+		log.Infof("id=%v reason=%v", r.uid, reason)
+		r.setState(ClientStateClosed)
+		close(r.notify)
+		r.closeIsolated()
+		pub, sub := r.GetPubTransport(), r.GetSubTransport()
+		if pub != nil {
+			pub.pc.Close()
+		}
+		if sub != nil {
+			sub.pc.Close()
+		}
+		r.closeRTPs()
+		r.cancel()
+		if onClose := r.callbacks().OnClose; onClose != nil {
+			onClose(reason)
+		}
+	})
 }
@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"sync/atomic"
+
+	log "github.com/pion/ion-log"
+	"github.com/pion/rtp"
+)
+
+// buffered packets of a SubscribeRTP channel
+const rtpChanSize = 512
+
+// rtpChan push packets of a track being read to the consumer
+type rtpChan struct {
+	// nil until SubscribeRTP, set once
+	c       chan *rtp.Packet
+	dropped uint64
+}
+
+// SubscribeRTP return a channel of the rtp packets of a remote track being read by the default read loop(OnTrack
+// not set, e.g. after WaitForTrack), the channel is closed when the track ends or the client is closed, and is
+// returned closed if the track is not being read. Packets are dropped if the consumer is slow, see RTPDropped
+func (r *RTC) SubscribeRTP(trackID string) <-chan *rtp.Packet {
+	r.rtpLock.Lock()
+	defer r.rtpLock.Unlock()
+	ch, ok := r.rtpChans[trackID]
+	if !ok {
+		c := make(chan *rtp.Packet)
+		close(c)
+		return c
+	}
+	if ch.c == nil {
+		ch.c = make(chan *rtp.Packet, rtpChanSize)
+	}
+	return ch.c
+}
+
+// openRTP register a track read by the default read loop for SubscribeRTP, closeRTP when it ends
+func (r *RTC) openRTP(trackID string) {
+	r.rtpLock.Lock()
+	defer r.rtpLock.Unlock()
+	r.rtpChans[trackID] = &rtpChan{}
+}
+
+// subscribedRTP get the SubscribeRTP channel of a track
+func (r *RTC) subscribedRTP(trackID string) (*rtpChan, bool) {
+	r.rtpLock.Lock()
+	defer r.rtpLock.Unlock()
+	ch, ok := r.rtpChans[trackID]
+	return ch, ok && ch.c != nil
+}
+
+// RTPDropped return the number of packets dropped for a slow SubscribeRTP consumer
+func (r *RTC) RTPDropped(trackID string) uint64 {
+	r.rtpLock.Lock()
+	defer r.rtpLock.Unlock()
+	if ch, ok := r.rtpChans[trackID]; ok {
+		return atomic.LoadUint64(&ch.dropped)
+	}
+	return 0
+}
+
+// pushRTP push a packet to the SubscribeRTP channel of the track if any
+func (r *RTC) pushRTP(trackID string, buf []byte) {
+	ch, ok := r.subscribedRTP(trackID)
+	if !ok {
+		return
+	}
+	// buf is reused by the read loop
+	pkt := &rtp.Packet{}
+	if err := pkt.Unmarshal(append([]byte(nil), buf...)); err != nil {
+		log.Debugf("id=%v unmarshal rtp err=%v", r.uid, err)
+		return
+	}
+	pushPacket(ch, pkt)
+}
+
+// unwrapRED unwrap a RED packet read into buf to the media packets
+func (r *RTC) unwrapRED(red *REDReceiver, buf []byte) []*rtp.Packet {
+	pkt := &rtp.Packet{}
+	if err := pkt.Unmarshal(append([]byte(nil), buf...)); err != nil {
+		log.Debugf("id=%v unmarshal rtp err=%v", r.uid, err)
+		return nil
+	}
+	pkts, err := red.Unwrap(pkt)
+	if err != nil {
+		log.Debugf("id=%v unwrap red err=%v", r.uid, err)
+		return nil
+	}
+	return pkts
+}
+
+// pushPackets push unwrapped media packets to the SubscribeRTP channel of the track if any
+func (r *RTC) pushPackets(trackID string, pkts []*rtp.Packet) {
+	ch, ok := r.subscribedRTP(trackID)
+	if !ok {
+		return
+	}
+	for _, p := range pkts {
+		pushPacket(ch, p)
+	}
+}
+
+// pushPacket push a packet to a SubscribeRTP channel, drop if full
+func pushPacket(ch *rtpChan, pkt *rtp.Packet) {
+	select {
+	case ch.c <- pkt:
+	default:
+		atomic.AddUint64(&ch.dropped, 1)
+	}
+}
+
+// closeRTP close the SubscribeRTP channel of an ended track
+func (r *RTC) closeRTP(trackID string) {
+	r.rtpLock.Lock()
+	defer r.rtpLock.Unlock()
+	if ch, ok := r.rtpChans[trackID]; ok {
+		if ch.c != nil {
+			close(ch.c)
+		}
+		delete(r.rtpChans, trackID)
+	}
+}
+
+// closeRTPs close the SubscribeRTP channels of all tracks on close
+func (r *RTC) closeRTPs() {
+	r.rtpLock.Lock()
+	defer r.rtpLock.Unlock()
+	for trackID, ch := range r.rtpChans {
+		if ch.c != nil {
+			close(ch.c)
+		}
+		delete(r.rtpChans, trackID)
+	}
+}
@@ -0,0 +1,37 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+func TestSubscribeRTP(t *testing.T) {
+	r := withConfig()
+	// not being read, e.g. ended or never arrived
+	if _, ok := <-r.SubscribeRTP("video"); ok {
+		t.Error("got a packet of a track not read")
+	}
+
+	r.openRTP("video")
+	ch := r.SubscribeRTP("video")
+	r.pushPackets("video", []*rtp.Packet{{Header: rtp.Header{SequenceNumber: 1}}})
+	if pkt := <-ch; pkt.SequenceNumber != 1 {
+		t.Errorf("got seq %v, want 1", pkt.SequenceNumber)
+	}
+	r.closeRTP("video")
+	if _, ok := <-ch; ok {
+		t.Error("channel not closed when the track ended")
+	}
+
+	// closed with the client
+	r.openRTP("audio")
+	ch = r.SubscribeRTP("audio")
+	r.Close()
+	if _, ok := <-ch; ok {
+		t.Error("channel not closed with the client")
+	}
+	if len(r.rtpChans) != 0 {
+		t.Errorf("%v channels left after close", len(r.rtpChans))
+	}
+}
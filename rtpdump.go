@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+)
+
+// rtpDumpWriter write rtp packets in rtpdump format, which can be read by wireshark or rtpplay
+type rtpDumpWriter struct {
+	file  *os.File
+	w     *bufio.Writer
+	start time.Time
+}
+
+// newRTPDumpWriter create a rtpdump file and write the file header
+func newRTPDumpWriter(path string) (*rtpDumpWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	d := &rtpDumpWriter{
+		file:  f,
+		w:     bufio.NewWriter(f),
+		start: time.Now(),
+	}
+
+	// "#!rtpplay1.0 address/port\n" then RD_hdr_t: start sec, start usec, source, port, padding
+	hdr := make([]byte, 16)
+	binary.BigEndian.PutUint32(hdr[0:], uint32(d.start.Unix()))
+	binary.BigEndian.PutUint32(hdr[4:], uint32(d.start.Nanosecond()/1000))
+	if _, err = fmt.Fprintf(d.w, "#!rtpplay1.0 0.0.0.0/0\n"); err == nil {
+		_, err = d.w.Write(hdr)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// WriteRTP write a raw rtp packet, RD_packet_t: length, plen, offset(ms) then the packet
+func (d *rtpDumpWriter) WriteRTP(pkt []byte) error {
+	hdr := make([]byte, 8)
+	binary.BigEndian.PutUint16(hdr[0:], uint16(len(pkt)+8))
+	binary.BigEndian.PutUint16(hdr[2:], uint16(len(pkt)))
+	binary.BigEndian.PutUint32(hdr[4:], uint32(time.Since(d.start).Milliseconds()))
+	if _, err := d.w.Write(hdr); err != nil {
+		return err
+	}
+	_, err := d.w.Write(pkt)
+	return err
+}
+
+func (d *rtpDumpWriter) Close() error {
+	if err := d.w.Flush(); err != nil {
+		d.file.Close()
+		return err
+	}
+	return d.file.Close()
+}
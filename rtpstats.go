@@ -0,0 +1,328 @@
+package engine
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// GetStats of the pinned pion has no rtp stream stats(inbound-rtp, outbound-rtp, remote-inbound-rtp),
+// so the rtp/rtcp passing the interceptors of each transport is counted here, see TrackStats
+
+// streamCounters rtp counters of a stream by ssrc
+type streamCounters struct {
+	ssrc      uint32
+	video     bool
+	clockRate uint32
+	// payload bytes and packets sent or received
+	bytes   uint64
+	packets uint64
+	// video frames sent or received, by the marker bit
+	frames uint32
+	// NACK packets received(outbound) or sent(inbound)
+	nacks   uint32
+	lastRTP time.Time
+
+	// inbound, rfc3550 A.1/A.8
+	started bool
+	baseSeq uint16
+	maxSeq  uint16
+	// seq cycles shifted by 16
+	cycles      uint32
+	lastTS      uint32
+	lastArrival float64
+	// in timestamp units
+	jitter float64
+
+	// outbound, from the reception reports of sfu
+	remoteLost   int32
+	remoteJitter uint32
+	// in seconds, 0 unless sender reports are sent(e.g. the report interceptor is registered)
+	rtt float64
+}
+
+// lost get the packets lost, for inbound expected - received, for outbound reported by sfu
+func (c *streamCounters) lost(inbound bool) int64 {
+	if !inbound {
+		return int64(c.remoteLost)
+	}
+	if !c.started {
+		return 0
+	}
+	expected := int64(c.cycles) + int64(c.maxSeq) - int64(c.baseSeq) + 1
+	// duplicates
+	if lost := expected - int64(c.packets); lost > 0 {
+		return lost
+	}
+	return 0
+}
+
+// jitterSeconds get the interarrival jitter, for outbound reported by sfu
+func (c *streamCounters) jitterSeconds(inbound bool) float64 {
+	if c.clockRate == 0 {
+		return 0
+	}
+	if !inbound {
+		return float64(c.remoteJitter) / float64(c.clockRate)
+	}
+	return c.jitter / float64(c.clockRate)
+}
+
+// onSent count a packet sent
+func (c *streamCounters) onSent(header *rtp.Header, payloadLen int, now time.Time) {
+	c.bytes += uint64(payloadLen)
+	c.packets++
+	if c.video && header.Marker {
+		c.frames++
+	}
+	c.lastRTP = now
+}
+
+// onReceived count a packet received, update the highest seq and the jitter
+func (c *streamCounters) onReceived(header *rtp.Header, payloadLen int, now time.Time) {
+	c.bytes += uint64(payloadLen)
+	c.packets++
+	if c.video && header.Marker {
+		c.frames++
+	}
+	c.lastRTP = now
+
+	arrival := float64(now.UnixNano()) / 1e9 * float64(c.clockRate)
+	if !c.started {
+		c.started = true
+		c.baseSeq, c.maxSeq = header.SequenceNumber, header.SequenceNumber
+		c.lastTS, c.lastArrival = header.Timestamp, arrival
+		return
+	}
+	if diff := header.SequenceNumber - c.maxSeq; diff != 0 && diff < 1<<15 {
+		if header.SequenceNumber < c.maxSeq {
+			c.cycles += 1 << 16
+		}
+		c.maxSeq = header.SequenceNumber
+	}
+	if c.clockRate > 0 {
+		d := (arrival - c.lastArrival) - float64(int32(header.Timestamp-c.lastTS))
+		c.jitter += (math.Abs(d) - c.jitter) / 16
+	}
+	c.lastTS, c.lastArrival = header.Timestamp, arrival
+}
+
+// onReport update an outbound stream by a reception report received at now
+func (c *streamCounters) onReport(report rtcp.ReceptionReport, now time.Time) {
+	// 24 bits signed
+	lost := int32(report.TotalLost & 0xffffff)
+	if lost&0x800000 != 0 {
+		lost -= 1 << 24
+	}
+	c.remoteLost = lost
+	c.remoteJitter = report.Jitter
+	if report.LastSenderReport == 0 {
+		return
+	}
+	// the middle 32 bits of ntp time, in 1/65536 seconds
+	rtt := ntpCompact(now) - report.LastSenderReport - report.Delay
+	if rtt < 1<<31 {
+		c.rtt = float64(rtt) / 65536
+	}
+}
+
+// ntpCompact get the middle 32 bits of the ntp timestamp of t
+func ntpCompact(t time.Time) uint32 {
+	// seconds from 1900 to 1970
+	const ntpEpochOffset = 2208988800
+	nsec := uint64(t.UnixNano())
+	sec := nsec/1e9 + ntpEpochOffset
+	frac := (nsec % 1e9) << 32 / 1e9
+	return uint32(sec<<16 | frac>>16)
+}
+
+// streamStats the rtp counters of a transport
+type streamStats struct {
+	streams map[uint32]*streamCounters
+	sync.Mutex
+}
+
+func newStreamStats() *streamStats {
+	return &streamStats{streams: make(map[uint32]*streamCounters)}
+}
+
+// add start counting a stream
+func (s *streamStats) add(info *interceptor.StreamInfo) *streamCounters {
+	s.Lock()
+	defer s.Unlock()
+	c := &streamCounters{
+		ssrc:      info.SSRC,
+		video:     strings.HasPrefix(strings.ToLower(info.MimeType), "video/"),
+		clockRate: info.ClockRate,
+	}
+	s.streams[info.SSRC] = c
+	return c
+}
+
+// remove stop counting a stream
+func (s *streamStats) remove(ssrc uint32) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.streams, ssrc)
+}
+
+// stream get a copy of the counters of ssrc
+func (s *streamStats) stream(ssrc uint32) (streamCounters, bool) {
+	s.Lock()
+	defer s.Unlock()
+	c, ok := s.streams[ssrc]
+	if !ok {
+		return streamCounters{}, false
+	}
+	return *c, true
+}
+
+// all get a copy of the counters of all streams
+func (s *streamStats) all() []streamCounters {
+	s.Lock()
+	defer s.Unlock()
+	streams := make([]streamCounters, 0, len(s.streams))
+	for _, c := range s.streams {
+		streams = append(streams, *c)
+	}
+	return streams
+}
+
+// lastRTP get the time of the last rtp of all streams, zero if none
+func (s *streamStats) lastRTP() time.Time {
+	s.Lock()
+	defer s.Unlock()
+	var last time.Time
+	for _, c := range s.streams {
+		if c.lastRTP.After(last) {
+			last = c.lastRTP
+		}
+	}
+	return last
+}
+
+// update run f on the counters of a stream
+func (s *streamStats) update(c *streamCounters, f func(c *streamCounters)) {
+	s.Lock()
+	defer s.Unlock()
+	f(c)
+}
+
+// onRTCP count the NACKs of the streams and apply the reception reports
+func (s *streamStats) onRTCP(pkts []rtcp.Packet) {
+	now := time.Now()
+	s.Lock()
+	defer s.Unlock()
+	for _, pkt := range pkts {
+		var reports []rtcp.ReceptionReport
+		switch pkt := pkt.(type) {
+		case *rtcp.TransportLayerNack:
+			if c, ok := s.streams[pkt.MediaSSRC]; ok {
+				c.nacks++
+			}
+		case *rtcp.ReceiverReport:
+			reports = pkt.Reports
+		case *rtcp.SenderReport:
+			reports = pkt.Reports
+		}
+		for _, report := range reports {
+			if c, ok := s.streams[report.SSRC]; ok {
+				c.onReport(report, now)
+			}
+		}
+	}
+}
+
+// statsInterceptorFactory build statsInterceptor for both transports
+type statsInterceptorFactory struct {
+	stats *streamStats
+}
+
+// NewInterceptor implements interceptor.Factory
+func (f *statsInterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &statsInterceptor{stats: f.stats}, nil
+}
+
+// statsInterceptor count the rtp sent and received, and the rtcp feedback of the streams
+type statsInterceptor struct {
+	interceptor.NoOp
+	stats *streamStats
+}
+
+// BindRTCPReader implements interceptor.Interceptor
+func (i *statsInterceptor) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err != nil {
+			return n, attr, err
+		}
+		if pkts, err := rtcp.Unmarshal(b[:n]); err == nil {
+			i.stats.onRTCP(pkts)
+		}
+		return n, attr, nil
+	})
+}
+
+// BindRTCPWriter implements interceptor.Interceptor
+func (i *statsInterceptor) BindRTCPWriter(writer interceptor.RTCPWriter) interceptor.RTCPWriter {
+	return interceptor.RTCPWriterFunc(func(pkts []rtcp.Packet, attributes interceptor.Attributes) (int, error) {
+		i.stats.onRTCP(pkts)
+		return writer.Write(pkts, attributes)
+	})
+}
+
+// BindLocalStream implements interceptor.Interceptor
+func (i *statsInterceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	c := i.stats.add(info)
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		n, err := writer.Write(header, payload, attributes)
+		if err == nil {
+			i.stats.update(c, func(c *streamCounters) {
+				c.onSent(header, len(payload), time.Now())
+			})
+		}
+		return n, err
+	})
+}
+
+// UnbindLocalStream implements interceptor.Interceptor
+func (i *statsInterceptor) UnbindLocalStream(info *interceptor.StreamInfo) {
+	i.stats.remove(info.SSRC)
+}
+
+// BindRemoteStream implements interceptor.Interceptor
+func (i *statsInterceptor) BindRemoteStream(info *interceptor.StreamInfo, reader interceptor.RTPReader) interceptor.RTPReader {
+	c := i.stats.add(info)
+	return interceptor.RTPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err != nil {
+			return n, attr, err
+		}
+		header := &rtp.Header{}
+		headerSize, err := header.Unmarshal(b[:n])
+		if err != nil {
+			return n, attr, nil
+		}
+		payloadLen := n - headerSize
+		if header.Padding && payloadLen > 0 {
+			payloadLen -= int(b[n-1])
+		}
+		if payloadLen < 0 {
+			payloadLen = 0
+		}
+		i.stats.update(c, func(c *streamCounters) {
+			c.onReceived(header, payloadLen, time.Now())
+		})
+		return n, attr, nil
+	})
+}
+
+// UnbindRemoteStream implements interceptor.Interceptor
+func (i *statsInterceptor) UnbindRemoteStream(info *interceptor.StreamInfo) {
+	i.stats.remove(info.SSRC)
+}
@@ -0,0 +1,65 @@
+package engine
+
+import (
+	log "github.com/pion/ion-log"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+)
+
+// stream label of screen share, see PublishScreen
+const screenLabel = "screen"
+
+// PublishScreen publish a screen share video track sendonly, labeled "screen" and marked a=content:slides(RFC 4796)
+// in the offer, so sfu and receivers can prefer resolution over framerate(maintain-resolution).
+// pion senders have no degradationPreference, the encoder producing the track should keep its resolution.
+func (r *RTC) PublishScreen(track webrtc.TrackLocal) (*webrtc.RTPSender, error) {
+	if track == nil || track.Kind() != webrtc.RTPCodecTypeVideo {
+		return nil, errInvalidKind
+	}
+	r.SetStreamLabel(track.StreamID(), screenLabel)
+	senders, err := r.PublishWithDirection(webrtc.RTPTransceiverDirectionSendonly, track)
+	if err != nil {
+		return nil, err
+	}
+	return senders[0], nil
+}
+
+// pubSDP munge the pub offer sent to sfu, the local description is not changed
+func (r *RTC) pubSDP(offer string) string {
+	return r.bandwidthSDP(r.contentSDP(r.ridSDP(offer)))
+}
+
+// contentSDP add a=content:slides to the media sections of screen share tracks
+func (r *RTC) contentSDP(offer string) string {
+	pub := r.GetPubTransport()
+	mids := make(map[string]bool)
+	r.labelLock.Lock()
+	for _, t := range pub.pc.GetTransceivers() {
+		if track := t.Sender().Track(); track != nil && t.Mid() != "" && r.streamLabels[track.StreamID()] == screenLabel {
+			mids[t.Mid()] = true
+		}
+	}
+	r.labelLock.Unlock()
+	if len(mids) == 0 {
+		return offer
+	}
+
+	desc := &sdp.SessionDescription{}
+	if err := desc.Unmarshal([]byte(offer)); err != nil {
+		log.Errorf("id=%v contentSDP err=%v", r.uid, err)
+		return offer
+	}
+	for _, m := range desc.MediaDescriptions {
+		mid, _ := m.Attribute(sdp.AttrKeyMID)
+		if _, exist := m.Attribute("content"); !mids[mid] || exist {
+			continue
+		}
+		m.WithValueAttribute("content", "slides")
+	}
+	marshalled, err := desc.Marshal()
+	if err != nil {
+		log.Errorf("id=%v contentSDP err=%v", r.uid, err)
+		return offer
+	}
+	return string(marshalled)
+}
@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"fmt"
+
+	log "github.com/pion/ion-log"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+)
+
+// validateRemoteSDP check a sdp from sfu before SetRemoteDescription, signaling is untrusted,
+// a rejected sdp leaves the transport in its last negotiated state
+func validateRemoteSDP(desc webrtc.SessionDescription) error {
+	if desc.SDP == "" {
+		return fmt.Errorf("%w: empty %v", errInvalidSDP, desc.Type)
+	}
+	parsed, err := desc.Unmarshal()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidSDP, err)
+	}
+	if len(parsed.MediaDescriptions) == 0 {
+		return fmt.Errorf("%w: no media section", errInvalidSDP)
+	}
+	// ice and dtls parameters may be at session or media level
+	_, ufrag := parsed.Attribute("ice-ufrag")
+	_, pwd := parsed.Attribute("ice-pwd")
+	_, fingerprint := parsed.Attribute("fingerprint")
+	for i, m := range parsed.MediaDescriptions {
+		if _, ok := m.Attribute(sdp.AttrKeyMID); !ok {
+			return fmt.Errorf("%w: media section %d without mid", errInvalidSDP, i)
+		}
+		_, mUfrag := m.Attribute("ice-ufrag")
+		_, mPwd := m.Attribute("ice-pwd")
+		_, mFingerprint := m.Attribute("fingerprint")
+		ufrag, pwd, fingerprint = ufrag || mUfrag, pwd || mPwd, fingerprint || mFingerprint
+	}
+	if !ufrag || !pwd {
+		return fmt.Errorf("%w: no ice credentials", errInvalidSDP)
+	}
+	if !fingerprint {
+		return fmt.Errorf("%w: no dtls fingerprint", errInvalidSDP)
+	}
+	return nil
+}
+
+// recoverSDP turn a panic on a malformed sdp into err, deferred by the negotiation steps
+func (r *RTC) recoverSDP(err *error) {
+	if p := recover(); p != nil {
+		*err = fmt.Errorf("%w: %v", errInvalidSDP, p)
+		log.Errorf("id=%v recovered err=%v", r.uid, *err)
+	}
+}
+
+// mediaMids get the mids of the media sections of a sdp
+func mediaMids(desc *sdp.SessionDescription) []string {
+	mids := make([]string, 0, len(desc.MediaDescriptions))
+	for _, m := range desc.MediaDescriptions {
+		mid, _ := m.Attribute(sdp.AttrKeyMID)
+		mids = append(mids, mid)
+	}
+	return mids
+}
+
+// checkMediaSections check the media sections of the answer line up with the offer,
+// SetRemoteDescription may succeed with mismatched sections, then no media flows
+func checkMediaSections(offer, answer *sdp.SessionDescription) error {
+	offerMids, answerMids := mediaMids(offer), mediaMids(answer)
+	if len(offerMids) != len(answerMids) {
+		return fmt.Errorf("%w: offer has %d media sections, answer has %d", errSDPMismatch, len(offerMids), len(answerMids))
+	}
+	for i := range offerMids {
+		if offerMids[i] != answerMids[i] {
+			return fmt.Errorf("%w: media section %d mid %v in offer, %v in answer", errSDPMismatch, i, offerMids[i], answerMids[i])
+		}
+	}
+	return nil
+}
+
+// verifyMediaSections check the current local and remote sdp of a transport, fire OnError on mismatch
+func (r *RTC) verifyMediaSections(t *Transport) {
+	local, remote := t.pc.LocalDescription(), t.pc.RemoteDescription()
+	if local == nil || remote == nil {
+		return
+	}
+	localSDP, err := local.Unmarshal()
+	if err != nil {
+		return
+	}
+	remoteSDP, err := remote.Unmarshal()
+	if err != nil {
+		return
+	}
+	if err := checkMediaSections(localSDP, remoteSDP); err != nil {
+		log.Errorf("id=%v target=%v err=%v", r.uid, t.role, err)
+		r.onError(err)
+	}
+}
@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"fmt"
+
+	log "github.com/pion/ion-log"
+	"github.com/pion/webrtc/v3"
+)
+
+// SendLayer is a published simulcast layer, a track sent with rid(see PublishFileRID)
+type SendLayer struct {
+	TrackID string
+	RID     string
+	Active  bool
+}
+
+// SendLayers enumerate the published simulcast layers
+func (r *RTC) SendLayers() []SendLayer {
+	r.ridLock.Lock()
+	defer r.ridLock.Unlock()
+	layers := make([]SendLayer, 0, len(r.trackRIDs))
+	for trackID, rid := range r.trackRIDs {
+		_, paused := r.pausedLayers[trackID]
+		layers = append(layers, SendLayer{TrackID: trackID, RID: rid, Active: !paused})
+	}
+	return layers
+}
+
+// SetSendLayerActive pause or resume sending a simulcast layer to save uplink, e.g. the high layer nobody watches,
+// without renegotiation or sfu signaling. pion has no RTPSender.SetParameters to toggle the encoding,
+// so the sender's track is replaced by nil while paused, the source keeps producing and its samples are dropped.
+func (r *RTC) SetSendLayerActive(trackID, rid string, active bool) error {
+	pub := r.GetPubTransport()
+	if pub == nil {
+		return ErrNotJoined
+	}
+	r.ridLock.Lock()
+	defer r.ridLock.Unlock()
+	if r.trackRIDs[trackID] != rid {
+		return errInvalidTrack
+	}
+	paused, isPaused := r.pausedLayers[trackID]
+	if active != isPaused {
+		return nil
+	}
+	for _, t := range pub.pc.GetTransceivers() {
+		sender := t.Sender()
+		if sender == nil {
+			continue
+		}
+		if active {
+			if sender != paused.sender {
+				continue
+			}
+			if err := sender.ReplaceTrack(paused.track); err != nil {
+				return err
+			}
+			delete(r.pausedLayers, trackID)
+			log.Infof("id=%v resume send layer track=%v rid=%v", r.uid, trackID, rid)
+			return nil
+		}
+		if track := sender.Track(); track != nil && track.ID() == trackID {
+			if err := sender.ReplaceTrack(nil); err != nil {
+				return err
+			}
+			r.pausedLayers[trackID] = pausedLayer{sender: sender, track: track}
+			log.Infof("id=%v pause send layer track=%v rid=%v", r.uid, trackID, rid)
+			return nil
+		}
+	}
+	return errInvalidTrack
+}
+
+// pausedLayer is the sender and track of a layer paused by SetSendLayerActive
+type pausedLayer struct {
+	sender *webrtc.RTPSender
+	track  webrtc.TrackLocal
+}
+
+// SetEncodingParameters set scaleResolutionDownBy/maxBitrate/maxFramerate of the simulcast layers of a published track
+// by rid. RTPSender.SetParameters is not available and RTPEncodingParameters has no such fields, so after
+// checking the track and the rids it returns ErrEncodingNotSupported, scale the source of each layer instead
+// (e.g. publish each layer from its own encoder by PublishFileRID)
+func (r *RTC) SetEncodingParameters(trackID string, params []webrtc.RTPEncodingParameters) error {
+	pub := r.GetPubTransport()
+	if pub == nil {
+		return ErrNotJoined
+	}
+	if len(params) == 0 {
+		return errInvalidParams
+	}
+	for _, p := range params {
+		if _, ok := ridQuality[p.RID]; p.RID != "" && !ok {
+			return errInvalidParams
+		}
+	}
+	for _, sender := range pub.pc.GetSenders() {
+		if track := sender.Track(); track != nil && track.ID() == trackID {
+			return fmt.Errorf("%w: trackId=%v", ErrEncodingNotSupported, trackID)
+		}
+	}
+	return errInvalidTrack
+}
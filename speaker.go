@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"encoding/json"
+	"time"
+
+	log "github.com/pion/ion-log"
+	"github.com/pion/webrtc/v3"
+)
+
+// a new dominant speaker must hold this long to switch layers, avoid flapping
+const speakerHold = 500 * time.Millisecond
+
+// speakerFollow state of SpeakerFollowsVideo
+type speakerFollow struct {
+	high, low string
+	dominant  string
+	timer     *time.Timer
+	// rid selected by stream id
+	layers map[string]string
+}
+
+// onAPIMessage handle the messages of sfu on api datachannel, audio levels(speaker stream ids, loudest first)
+func (r *RTC) onAPIMessage(msg webrtc.DataChannelMessage) {
+	var event []string
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		log.Debugf("id=%v ignore api message=%s", r.uid, msg.Data)
+		return
+	}
+	r.speaker(event)
+}
+
+// SpeakerFollowsVideo subscribe the video of the dominant speaker(the loudest of OnSpeaker) with highLayer and
+// the others with lowLayer(f/h/q), switching after the speaker holds for a while, empty layers to disable
+func (r *RTC) SpeakerFollowsVideo(highLayer, lowLayer string) error {
+	r.followLock.Lock()
+	defer r.followLock.Unlock()
+	if r.follow != nil && r.follow.timer != nil {
+		r.follow.timer.Stop()
+	}
+	if highLayer == "" && lowLayer == "" {
+		r.follow = nil
+		return nil
+	}
+	if _, ok := ridQuality[highLayer]; !ok {
+		return errInvalidParams
+	}
+	if _, ok := ridQuality[lowLayer]; !ok {
+		return errInvalidParams
+	}
+	r.follow = &speakerFollow{high: highLayer, low: lowLayer, layers: make(map[string]string)}
+	return nil
+}
+
+// followSpeaker switch layers by a speaker event after speakerHold
+func (r *RTC) followSpeaker(event []string) {
+	if len(event) == 0 {
+		return
+	}
+	dominant := event[0]
+	r.followLock.Lock()
+	defer r.followLock.Unlock()
+	f := r.follow
+	if f == nil || f.dominant == dominant {
+		if f != nil && f.timer != nil {
+			// back to the current speaker before hold
+			f.timer.Stop()
+		}
+		return
+	}
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	f.timer = time.AfterFunc(speakerHold, func() {
+		r.applySpeaker(f, dominant)
+	})
+}
+
+// applySpeaker select the layers of remote video streams for the dominant speaker
+func (r *RTC) applySpeaker(f *speakerFollow, dominant string) {
+	r.followLock.Lock()
+	if r.follow != f {
+		r.followLock.Unlock()
+		return
+	}
+	f.dominant = dominant
+	r.remoteLock.Lock()
+	layers := make(map[string]string)
+	for _, t := range r.remoteTracks {
+		if t.Kind != webrtc.RTPCodecTypeVideo.String() {
+			continue
+		}
+		layer := f.low
+		if t.StreamId == dominant {
+			layer = f.high
+		}
+		if f.layers[t.StreamId] != layer {
+			layers[t.StreamId] = layer
+		}
+		f.layers[t.StreamId] = layer
+	}
+	r.remoteLock.Unlock()
+	r.followLock.Unlock()
+
+	log.Infof("id=%v dominant speaker streamId=%v", r.uid, dominant)
+	for streamID, layer := range layers {
+		audio := true
+		r.callLock.Lock()
+		if call, ok := r.calls[streamID]; ok {
+			audio = call.Audio
+		}
+		r.callLock.Unlock()
+		if err := r.selectRemote(streamID, ridQuality[layer], audio); err != nil {
+			log.Errorf("id=%v selectRemote streamId=%v err=%v", r.uid, streamID, err)
+		}
+	}
+}
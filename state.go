@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"fmt"
+
+	log "github.com/pion/ion-log"
+)
+
+// ClientState is the lifecycle state of a RTC
+type ClientState int
+
+const (
+	// ClientStateNew created, tracks and datachannels may be added for the join offer
+	ClientStateNew ClientState = iota
+	// ClientStateJoining the join request is sent
+	ClientStateJoining
+	// ClientStateJoined the sfu accepted the join
+	ClientStateJoined
+	// ClientStateReconnecting ICE is disconnected, pion keeps checking and it may come back to joined
+	ClientStateReconnecting
+	// ClientStateClosed closed by Close or internally(see OnClose), final
+	ClientStateClosed
+)
+
+func (s ClientState) String() string {
+	switch s {
+	case ClientStateNew:
+		return "new"
+	case ClientStateJoining:
+		return "joining"
+	case ClientStateJoined:
+		return "joined"
+	case ClientStateReconnecting:
+		return "reconnecting"
+	case ClientStateClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+// State get the lifecycle state
+func (r *RTC) State() ClientState {
+	r.stateLock.Lock()
+	defer r.stateLock.Unlock()
+	return r.state
+}
+
+// setState change the state and fire OnStateChange, closed is final
+func (r *RTC) setState(state ClientState) {
+	r.stateLock.Lock()
+	old := r.state
+	if old == state || old == ClientStateClosed {
+		r.stateLock.Unlock()
+		return
+	}
+	r.state = state
+	r.stateLock.Unlock()
+	r.stateChanged(old, state)
+}
+
+// compareAndSetState change the state only from old, e.g. ICE changes apply to a joined client only
+func (r *RTC) compareAndSetState(old, state ClientState) {
+	r.stateLock.Lock()
+	if r.state != old || old == state || old == ClientStateClosed {
+		r.stateLock.Unlock()
+		return
+	}
+	r.state = state
+	r.stateLock.Unlock()
+	r.stateChanged(old, state)
+}
+
+// stateChanged fire OnStateChange out of stateLock
+func (r *RTC) stateChanged(old, state ClientState) {
+	log.Infof("id=%v state %v => %v", r.uid, old, state)
+	if onStateChange := r.callbacks().OnStateChange; onStateChange != nil {
+		onStateChange(state)
+	}
+}
+
+// checkState return an error if the state is not one of states
+func (r *RTC) checkState(states ...ClientState) error {
+	state := r.State()
+	for _, s := range states {
+		if state == s {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v", errInvalidState, state)
+}
+
+// joinOffered check if the join offer is created(or being created), later changes need renegotiation,
+// before it tracks and datachannels are added to the join offer
+func (r *RTC) joinOffered() bool {
+	state := r.State()
+	return state == ClientStateJoining || state == ClientStateJoined || state == ClientStateReconnecting
+}
+
+// checkJoined return ErrNotJoined if the transports are not created or the client is not joined(or reconnecting)
+func (r *RTC) checkJoined() error {
+	if r.pub == nil || r.sub == nil {
+		return ErrNotJoined
+	}
+	if state := r.State(); state != ClientStateJoined && state != ClientStateReconnecting {
+		return fmt.Errorf("%w: %v", ErrNotJoined, state)
+	}
+	return nil
+}
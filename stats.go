@@ -0,0 +1,242 @@
+package engine
+
+import (
+	"time"
+)
+
+const (
+	defaultStatsInterval = time.Second
+	// sub loss fraction to fire OnPacketLoss
+	defaultPacketLossThreshold = 0.05
+)
+
+// TrackStats common rtp stats of a track, counted from the rtp/rtcp of the transport(see rtpstats.go),
+// webrtc.StatsReport of the pinned pion has no rtp stream stats
+type TrackStats struct {
+	TrackID string
+	SSRC    uint32
+	Kind    string
+	// payload bytes sent or received
+	Bytes uint64
+	// packets sent or received
+	Packets uint32
+	// packets lost, for outbound reported by remote
+	Lost int32
+	// round trip time in seconds by the reception reports of remote, only outbound, 0 unless sender reports
+	// are sent(register the report interceptor in WebRTCTransportConfig.Interceptors)
+	RTT float64
+	// jitter in seconds, for outbound reported by remote
+	Jitter float64
+	// video frames sent or received
+	Frames uint32
+	// NACK packets received(outbound) or sent(inbound)
+	NACKCount uint32
+}
+
+// trackStats fill stats by the counters of its ssrc
+func (t *Transport) trackStats(stats *TrackStats, inbound bool) {
+	c, ok := t.stats.stream(stats.SSRC)
+	if !ok {
+		return
+	}
+	stats.Bytes = c.bytes
+	stats.Packets = uint32(c.packets)
+	stats.Lost = int32(c.lost(inbound))
+	stats.Jitter = c.jitterSeconds(inbound)
+	stats.Frames = c.frames
+	stats.NACKCount = c.nacks
+	if !inbound {
+		stats.RTT = c.rtt
+	}
+}
+
+// OutboundStats get the stats of a published track
+func (r *RTC) OutboundStats(trackID string) (TrackStats, error) {
+	pub := r.GetPubTransport()
+	stats := TrackStats{TrackID: trackID}
+	if pub == nil {
+		return stats, ErrNotJoined
+	}
+	found := false
+	for _, sender := range pub.pc.GetSenders() {
+		if t := sender.Track(); t != nil && t.ID() == trackID {
+			if encodings := sender.GetParameters().Encodings; len(encodings) > 0 {
+				stats.SSRC = uint32(encodings[0].SSRC)
+				stats.Kind = t.Kind().String()
+				found = true
+			}
+			break
+		}
+	}
+	if !found {
+		return stats, errInvalidTrack
+	}
+	pub.trackStats(&stats, false)
+	return stats, nil
+}
+
+// InboundStats get the stats of a subscribed track
+func (r *RTC) InboundStats(trackID string) (TrackStats, error) {
+	sub := r.GetSubTransport()
+	stats := TrackStats{TrackID: trackID}
+	if sub == nil {
+		return stats, ErrNotJoined
+	}
+	found := false
+	for _, receiver := range sub.pc.GetReceivers() {
+		for _, t := range receiver.Tracks() {
+			if t.ID() == trackID {
+				stats.SSRC = uint32(t.SSRC())
+				stats.Kind = t.Kind().String()
+				found = true
+				break
+			}
+		}
+		if found {
+			break
+		}
+	}
+	if !found {
+		return stats, errInvalidTrack
+	}
+	sub.trackStats(&stats, true)
+	return stats, nil
+}
+
+// statsLoop fire OnStats and OnPacketLoss every StatsInterval until Close
+func (r *RTC) statsLoop() {
+	interval := r.config.StatsInterval
+	if interval <= 0 {
+		interval = defaultStatsInterval
+	}
+	threshold := r.config.PacketLossThreshold
+	if threshold <= 0 {
+		threshold = defaultPacketLossThreshold
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var loss packetLoss
+	lossy := false
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			callbacks := r.callbacks()
+			onStats, onPacketLoss := callbacks.OnStats, callbacks.OnPacketLoss
+			if onStats != nil {
+				onStats(r.GetPubStats(), r.GetSubStats())
+			}
+			// replaced when joining again
+			sub := r.GetSubTransport()
+			if onPacketLoss == nil || sub == nil {
+				continue
+			}
+			fraction, ok := loss.sample(sub.stats.all())
+			if !ok {
+				continue
+			}
+			// fire when crossing the threshold, up and back down
+			if above := fraction >= threshold; above != lossy {
+				lossy = above
+				onPacketLoss(fraction)
+			}
+		}
+	}
+}
+
+// packetLoss sub loss fraction between samples
+type packetLoss struct {
+	lost     int64
+	received int64
+}
+
+// sample get the loss fraction of the inbound streams since last sample, false if no packet
+func (l *packetLoss) sample(streams []streamCounters) (float64, bool) {
+	var lost, received int64
+	for i := range streams {
+		lost += streams[i].lost(true)
+		received += int64(streams[i].packets)
+	}
+	deltaLost, deltaReceived := lost-l.lost, received-l.received
+	l.lost, l.received = lost, received
+	// streams removed or loss corrected by late packets
+	if deltaLost < 0 {
+		deltaLost = 0
+	}
+	if deltaReceived <= 0 {
+		return 0, false
+	}
+	return float64(deltaLost) / float64(deltaLost+deltaReceived), true
+}
+
+// DirectionStats totals of the tracks of one transport
+type DirectionStats struct {
+	Tracks  int
+	Bytes   uint64
+	Packets uint64
+	// packets lost, for up reported by remote
+	Lost int64
+	// lost / (lost + packets)
+	LossRate float64
+	// max round trip time of the tracks in seconds, only up, 0 unless sender reports are sent(see TrackStats)
+	RTT float64
+}
+
+// SessionStats a snapshot of both transports
+type SessionStats struct {
+	// pub
+	Up DirectionStats
+	// sub
+	Down DirectionStats
+	// totals of both directions
+	Tracks   int
+	Bytes    uint64
+	LossRate float64
+}
+
+// SessionStats get the merged stats of pub and sub, counted from the rtp and rtcp of the transports
+func (r *RTC) SessionStats() SessionStats {
+	pub, sub := r.GetPubTransport(), r.GetSubTransport()
+	var stats SessionStats
+	if pub == nil || sub == nil {
+		return stats
+	}
+	for _, sender := range pub.pc.GetSenders() {
+		if sender.Track() != nil {
+			stats.Up.Tracks++
+		}
+	}
+	for _, c := range pub.stats.all() {
+		stats.Up.Bytes += c.bytes
+		stats.Up.Packets += c.packets
+		stats.Up.Lost += c.lost(false)
+		if c.rtt > stats.Up.RTT {
+			stats.Up.RTT = c.rtt
+		}
+	}
+
+	for _, receiver := range sub.pc.GetReceivers() {
+		stats.Down.Tracks += len(receiver.Tracks())
+	}
+	for _, c := range sub.stats.all() {
+		stats.Down.Bytes += c.bytes
+		stats.Down.Packets += c.packets
+		stats.Down.Lost += c.lost(true)
+	}
+
+	stats.Up.LossRate = lossRate(stats.Up.Lost, stats.Up.Packets)
+	stats.Down.LossRate = lossRate(stats.Down.Lost, stats.Down.Packets)
+	stats.Tracks = stats.Up.Tracks + stats.Down.Tracks
+	stats.Bytes = stats.Up.Bytes + stats.Down.Bytes
+	stats.LossRate = lossRate(stats.Up.Lost+stats.Down.Lost, stats.Up.Packets+stats.Down.Packets)
+	return stats
+}
+
+func lossRate(lost int64, packets uint64) float64 {
+	if lost <= 0 {
+		return 0
+	}
+	return float64(lost) / (float64(lost) + float64(packets))
+}
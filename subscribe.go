@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"sort"
+
+	log "github.com/pion/ion-log"
+	"github.com/pion/webrtc/v3"
+)
+
+// SubscribeMode is how subscriptions reach the sfu, the sfu honors one of them,
+// mixing both(e.g. Subscribe with SubscribeSignal and SelectLayer) may subscribe twice
+type SubscribeMode string
+
+const (
+	// SubscribeSignal subscribe tracks by the signal Subscription request, ion >= v1.10
+	SubscribeSignal SubscribeMode = "signal"
+	// SubscribeAPI subscribe streams by the api datachannel(legacy ion-sfu), track subscriptions
+	// are merged by stream, video layer from Subscription.Layer
+	SubscribeAPI SubscribeMode = "api"
+)
+
+// updateRemoteTracks keep the remote tracks of track events
+func (r *RTC) updateRemoteTracks(event TrackEvent) {
+	r.remoteLock.Lock()
+	defer r.remoteLock.Unlock()
+	for _, t := range event.Tracks {
+		if event.State == TrackEvent_REMOVE {
+			delete(r.remoteTracks, t.Id)
+			delete(r.remoteUIDs, t.Id)
+			continue
+		}
+		r.remoteUIDs[t.Id] = event.Uid
+		info := *t
+		if old, ok := r.remoteTracks[t.Id]; ok {
+			info.Subscribe = old.Subscribe
+			info.Codec = old.Codec
+		}
+		r.remoteTracks[t.Id] = &info
+	}
+}
+
+// setRemoteCodec keep the negotiated codec of an arrived track
+func (r *RTC) setRemoteCodec(track *webrtc.TrackRemote) {
+	r.remoteLock.Lock()
+	defer r.remoteLock.Unlock()
+	if t, ok := r.remoteTracks[track.ID()]; ok {
+		t.Codec = track.Codec().MimeType
+	}
+}
+
+// remoteUID get the participant uid of an arrived track from track events, by track id or else stream id
+func (r *RTC) remoteUID(track *webrtc.TrackRemote) string {
+	r.remoteLock.Lock()
+	defer r.remoteLock.Unlock()
+	if uid, ok := r.remoteUIDs[track.ID()]; ok {
+		return uid
+	}
+	for id, t := range r.remoteTracks {
+		if t.StreamId == track.StreamID() {
+			return r.remoteUIDs[id]
+		}
+	}
+	return ""
+}
+
+// RemoteTrackInfo get the latest info of a remote track from track events, with the codec once it arrives
+// and the video width/height/framerate if signaled by sfu, e.g. for "1080p VP9" badges
+func (r *RTC) RemoteTrackInfo(trackID string) (TrackInfo, error) {
+	r.remoteLock.Lock()
+	defer r.remoteLock.Unlock()
+	t, ok := r.remoteTracks[trackID]
+	if !ok {
+		return TrackInfo{}, errInvalidTrack
+	}
+	return *t, nil
+}
+
+// subscribeAPI apply track subscriptions, then send the state of the streams by api datachannel
+func (r *RTC) subscribeAPI(subscriptions []*Subscription) error {
+	r.remoteLock.Lock()
+	streams := make(map[string]bool)
+	for _, s := range subscriptions {
+		t, ok := r.remoteTracks[s.TrackId]
+		if !ok {
+			r.remoteLock.Unlock()
+			return errInvalidTrack
+		}
+		t.Subscribe = s.Subscribe && !s.Mute
+		if s.Layer != "" {
+			t.Layer = s.Layer
+		}
+		streams[t.StreamId] = true
+	}
+
+	var calls []Call
+	for streamID := range streams {
+		call := Call{StreamID: streamID, Video: "none"}
+		for _, t := range r.remoteTracks {
+			if t.StreamId != streamID || !t.Subscribe {
+				continue
+			}
+			switch t.Kind {
+			case "audio":
+				call.Audio = true
+			case "video":
+				call.Video = "high"
+				if quality, ok := ridQuality[t.Layer]; ok {
+					call.Video = quality
+				}
+			}
+		}
+		calls = append(calls, call)
+	}
+	r.remoteLock.Unlock()
+
+	for _, call := range calls {
+		log.Infof("[C=>S] id=%v subscribe by api call=%+v", r.uid, call)
+		if err := r.sendCall(call); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SubscribeOptions per track options of SubscribeWithOptions
+type SubscribeOptions struct {
+	TrackID string
+	// initial simulcast layer(f/h/q), sfu default if empty
+	Layer string
+	// subscribed but muted, resume by another request with Paused false
+	Paused bool
+	// tracks of higher priority are requested first, and kept if the request exceeds MaxSubscriptions
+	Priority int
+}
+
+// SubscribeWithOptions subscribe tracks with per track options in one request, Subscribe is the simple form
+func (r *RTC) SubscribeWithOptions(options []SubscribeOptions) error {
+	if len(options) == 0 {
+		return errInvalidParams
+	}
+	options = append([]SubscribeOptions(nil), options...)
+	sort.SliceStable(options, func(i, j int) bool {
+		return options[i].Priority > options[j].Priority
+	})
+	if max := r.config.MaxSubscriptions; max > 0 && len(options) > max {
+		log.Infof("id=%v max subscriptions %v reached, skip %v tracks of lower priority", r.uid, max, len(options)-max)
+		options = options[:max]
+	}
+
+	subscriptions := make([]*Subscription, 0, len(options))
+	for _, o := range options {
+		if o.TrackID == "" {
+			return errInvalidTrack
+		}
+		if _, ok := ridQuality[o.Layer]; o.Layer != "" && !ok {
+			return errInvalidParams
+		}
+		subscriptions = append(subscriptions, &Subscription{
+			TrackId:   o.TrackID,
+			Mute:      o.Paused,
+			Subscribe: true,
+			Layer:     o.Layer,
+		})
+	}
+	return r.Subscribe(subscriptions)
+}
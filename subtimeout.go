@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"time"
+
+	log "github.com/pion/ion-log"
+)
+
+// watchSubscribe start the SubscribeTimeout of subscribed tracks, restart if already watched
+func (r *RTC) watchSubscribe(trackIDs ...string) {
+	timeout := r.config.SubscribeTimeout
+	if timeout <= 0 {
+		return
+	}
+	r.subTimerLock.Lock()
+	defer r.subTimerLock.Unlock()
+	for _, trackID := range trackIDs {
+		if timer, ok := r.subTimers[trackID]; ok {
+			timer.Stop()
+		}
+		trackID := trackID
+		r.subTimers[trackID] = time.AfterFunc(timeout, func() {
+			r.subscribeTimeout(trackID)
+		})
+	}
+}
+
+// unwatchSubscribe stop the SubscribeTimeout of unsubscribed or removed tracks
+func (r *RTC) unwatchSubscribe(trackIDs ...string) {
+	r.subTimerLock.Lock()
+	defer r.subTimerLock.Unlock()
+	for _, trackID := range trackIDs {
+		if timer, ok := r.subTimers[trackID]; ok {
+			timer.Stop()
+			delete(r.subTimers, trackID)
+		}
+	}
+}
+
+// subscribeTimeout check if rtp of the track arrived, fire OnSubscribeTimeout if not
+func (r *RTC) subscribeTimeout(trackID string) {
+	r.subTimerLock.Lock()
+	delete(r.subTimers, trackID)
+	r.subTimerLock.Unlock()
+	if r.ctx.Err() != nil {
+		return
+	}
+	if r.rtpArrived(trackID, time.Now().Add(-r.config.SubscribeTimeout)) {
+		return
+	}
+
+	log.Warnf("id=%v no rtp of trackId=%v in %v", r.uid, trackID, r.config.SubscribeTimeout)
+	if onSubscribeTimeout := r.callbacks().OnSubscribeTimeout; onSubscribeTimeout != nil {
+		onSubscribeTimeout(trackID)
+	}
+	if !r.config.UnsubscribeOnTimeout {
+		return
+	}
+	if err := r.Subscribe([]*Subscription{{TrackId: trackID, Subscribe: false}}); err != nil {
+		log.Errorf("id=%v unsubscribe trackId=%v err=%v", r.uid, trackID, err)
+	}
+}
+
+// rtpArrived check if rtp of a subscribed track was read since, counted on the read path of the sub transport,
+// so with OnTrack set the app must read the track
+func (r *RTC) rtpArrived(trackID string, since time.Time) bool {
+	sub := r.GetSubTransport()
+	if sub == nil {
+		return false
+	}
+	track := r.remoteTrack(trackID)
+	if track == nil {
+		return false
+	}
+	c, ok := sub.stats.stream(uint32(track.SSRC()))
+	return ok && c.lastRTP.After(since)
+}
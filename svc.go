@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	log "github.com/pion/ion-log"
+	"github.com/pion/webrtc/v3"
+)
+
+// scalabilityModeRegexp the scalability modes of webrtc-svc, e.g. L1T3, L3T3_KEY, S2T1h
+var scalabilityModeRegexp = regexp.MustCompile(`^[LS][1-3]T[1-3](h|_KEY|_KEY_SHIFT)?$`)
+
+// PublishSVC publish a VP9/AV1 video track encoded with a SVC scalability mode(e.g. L3T3) in one rtp stream,
+// instead of simulcast layers. pion has no RTPEncodingParameters.ScalabilityMode to configure the encoder,
+// so the track must already carry the layers(VP9 payload descriptor or AV1 dependency descriptor), sfu drops
+// layers by them. AV1 needs a MediaEngine registering it.
+func (r *RTC) PublishSVC(track webrtc.TrackLocal, scalabilityMode string) (*webrtc.RTPSender, error) {
+	if track == nil || track.Kind() != webrtc.RTPCodecTypeVideo || !scalabilityModeRegexp.MatchString(scalabilityMode) {
+		return nil, errInvalidParams
+	}
+	if codec, ok := track.(codecTrack); ok {
+		mime := codec.Codec().MimeType
+		if !strings.EqualFold(mime, MimeTypeVP9) && !strings.EqualFold(mime, MimeTypeAV1) {
+			return nil, fmt.Errorf("%w: %v has no SVC, use VP9 or AV1", errInvalidParams, mime)
+		}
+	}
+	senders, err := r.Publish(track)
+	if err != nil {
+		return nil, err
+	}
+	r.svcLock.Lock()
+	r.svcModes[track.ID()] = scalabilityMode
+	r.svcLock.Unlock()
+	log.Infof("id=%v PublishSVC track=%v mode=%v", r.uid, track.ID(), scalabilityMode)
+	return senders[0], nil
+}
+
+// ScalabilityMode get the scalability mode of a track published by PublishSVC, empty if not
+func (r *RTC) ScalabilityMode(trackID string) string {
+	r.svcLock.Lock()
+	defer r.svcLock.Unlock()
+	return r.svcModes[trackID]
+}
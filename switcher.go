@@ -0,0 +1,161 @@
+package engine
+
+import (
+	"sync"
+
+	log "github.com/pion/ion-log"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// switchSource is a source registered to SourceSwitcher
+type switchSource struct {
+	track webrtc.TrackLocal
+	// request a keyframe of the source, may be nil
+	keyframe func()
+	producer *WebMProducer
+}
+
+// SourceSwitcher publish one of several sources(files/live tracks) at a time on the same sender,
+// switching by ReplaceTrack without renegotiation, sources must have the same codec
+type SourceSwitcher struct {
+	rtc     *RTC
+	sender  *webrtc.RTPSender
+	sources map[string]*switchSource
+	active  string
+	sync.Mutex
+}
+
+// NewSourceSwitcher create a switcher, the sender is published at the first SwitchTo
+func (r *RTC) NewSourceSwitcher() *SourceSwitcher {
+	return &SourceSwitcher{
+		rtc:     r,
+		sources: make(map[string]*switchSource),
+	}
+}
+
+// AddSource register a live track, keyframe is called when switched to it or PLI received, may be nil
+func (s *SourceSwitcher) AddSource(name string, track webrtc.TrackLocal, keyframe func()) error {
+	if name == "" || track == nil {
+		return errInvalidParams
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.sources[name] = &switchSource{track: track, keyframe: keyframe}
+	return nil
+}
+
+// AddFileSource register the video of a webm file, it plays in loop, and sends from its next keyframe when switched to
+// or PLI received
+func (s *SourceSwitcher) AddFileSource(name, file string) error {
+	if name == "" {
+		return errInvalidParams
+	}
+	producer := NewWebMProducer(file, 0)
+	if producer == nil {
+		return errInvalidFile
+	}
+	track, err := producer.GetVideoTrack()
+	if err != nil {
+		return err
+	}
+	producer.Start()
+
+	s.Lock()
+	defer s.Unlock()
+	s.sources[name] = &switchSource{
+		track:    track,
+		producer: producer,
+		// no rewinding, the viewers would see the gop replayed on each PLI
+		keyframe: producer.requestKeyframe,
+	}
+	return nil
+}
+
+// SwitchTo make a registered source live, and request a keyframe of it
+func (s *SourceSwitcher) SwitchTo(name string) error {
+	keyframe, err := s.switchTo(name)
+	if err != nil {
+		return err
+	}
+	// out of the lock, keyframe of a live source may block
+	if keyframe != nil {
+		keyframe()
+	}
+	return nil
+}
+
+// switchTo replace the track by the source, return its keyframe func if switched
+func (s *SourceSwitcher) switchTo(name string) (func(), error) {
+	s.Lock()
+	defer s.Unlock()
+	source, ok := s.sources[name]
+	if !ok {
+		return nil, errInvalidTrack
+	}
+	if name == s.active {
+		return nil, nil
+	}
+
+	if s.sender == nil {
+		senders, err := s.rtc.Publish(source.track)
+		if err != nil {
+			return nil, err
+		}
+		s.sender = senders[0]
+		go s.readRTCP(s.sender)
+	} else if err := s.sender.ReplaceTrack(source.track); err != nil {
+		log.Errorf("id=%v SwitchTo %v ReplaceTrack err=%v", s.rtc.uid, name, err)
+		return nil, err
+	}
+	log.Infof("id=%v SwitchTo %v", s.rtc.uid, name)
+	s.active = name
+	return source.keyframe, nil
+}
+
+// Active return the name of the live source
+func (s *SourceSwitcher) Active() string {
+	s.Lock()
+	defer s.Unlock()
+	return s.active
+}
+
+// Close stop the file sources and unpublish the sender
+func (s *SourceSwitcher) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	for _, source := range s.sources {
+		if source.producer != nil {
+			source.producer.Stop()
+		}
+	}
+	s.sources = make(map[string]*switchSource)
+	s.active = ""
+	if s.sender == nil {
+		return nil
+	}
+	sender := s.sender
+	s.sender = nil
+	return s.rtc.UnPublish(sender)
+}
+
+// readRTCP forward PLI/FIR from sfu to the live source
+func (s *SourceSwitcher) readRTCP(sender *webrtc.RTPSender) {
+	for {
+		pkts, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, pkt := range pkts {
+			switch pkt.(type) {
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				s.Lock()
+				source, ok := s.sources[s.active]
+				s.Unlock()
+				if ok && source.keyframe != nil {
+					source.keyframe()
+				}
+			}
+		}
+	}
+}
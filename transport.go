@@ -1,8 +1,13 @@
 package engine
 
 import (
+	"sync"
+	"time"
+
 	"github.com/pion/ice/v2"
+	"github.com/pion/interceptor"
 	log "github.com/pion/ion-log"
+	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 )
 
@@ -14,13 +19,25 @@ type Transport struct {
 	role           Target
 	SendCandidates []*webrtc.ICECandidate
 	RecvCandidates []webrtc.ICECandidateInit
+
+	// local rtp tracks added by AddLocalTrackRTP
+	localTracks map[string]*webrtc.TrackLocalStaticRTP
+	// send side bandwidth estimation, only pub
+	bwe *sendEstimator
+	// ICE gathering started by PrepareConnection, only pub
+	prepared bool
+	// rtp counters of the streams, see TrackStats
+	stats *streamStats
+	sync.Mutex
 }
 
 // NewTransport create a transport
 func NewTransport(role Target, rtc *RTC) *Transport {
 	t := &Transport{
-		role: role,
-		rtc:  rtc,
+		role:        role,
+		rtc:         rtc,
+		localTracks: make(map[string]*webrtc.TrackLocalStaticRTP),
+		stats:       newStreamStats(),
 	}
 	if rtc.config == nil {
 		rtc.config = &DefaultConfig
@@ -29,19 +46,48 @@ func NewTransport(role Target, rtc *RTC) *Transport {
 	var api *webrtc.API
 	var me *webrtc.MediaEngine
 	rtc.config.WebRTC.Setting.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
-	if role == Target_PUBLISHER {
-		me, err = getPublisherMediaEngine(rtc.config.WebRTC.VideoMime)
+	rtc.config.WebRTC.ICE.apply(&rtc.config.WebRTC.Setting)
+	if rtc.config.WebRTC.MediaEngine != nil {
+		me = rtc.config.WebRTC.MediaEngine
+	} else if role == Target_PUBLISHER {
+		me, err = getPublisherMediaEngine(rtc.config.WebRTC.VideoMime, rtc.config.WebRTC.Audio)
 	} else {
 		me, err = getSubscriberMediaEngine()
 	}
+	if err == nil && rtc.config.WebRTC.MediaEngine == nil {
+		err = registerHeaderExtensions(me, rtc.config.WebRTC.HeaderExtensions)
+	}
+	if err == nil && rtc.config.WebRTC.MediaEngine == nil && role == Target_SUBSCRIBER && rtc.config.ReceiveRED {
+		err = registerRED(me)
+	}
 
 	if err != nil {
 		log.Errorf("getPublisherMediaEngine error: %v", err)
 		return nil
 	}
 
-	api = webrtc.NewAPI(webrtc.WithMediaEngine(me), webrtc.WithSettingEngine(rtc.config.WebRTC.Setting))
-	t.pc, err = api.NewPeerConnection(rtc.config.WebRTC.Configuration)
+	opts := []func(*webrtc.API){webrtc.WithMediaEngine(me), webrtc.WithSettingEngine(rtc.config.WebRTC.Setting)}
+	// a registry for this transport, the configured one may be shared
+	registry := &interceptor.Registry{}
+	if rtc.config.WebRTC.Interceptors != nil {
+		registry.Add(&registryFactory{registry: rtc.config.WebRTC.Interceptors})
+	}
+	if role == Target_PUBLISHER {
+		// for PublishFileRID
+		registry.Add(&ridInterceptorFactory{rtc: rtc})
+		// for EstimatedSendBitrate
+		t.bwe = newSendEstimator()
+		registry.Add(&bweInterceptorFactory{bwe: t.bwe})
+		// for SetHeaderExtension
+		registry.Add(&headerExtInterceptorFactory{rtc: rtc})
+	}
+	// for OnEncrypt/OnDecrypt
+	registry.Add(&e2eeInterceptorFactory{rtc: rtc})
+	// for OutboundStats/InboundStats
+	registry.Add(&statsInterceptorFactory{stats: t.stats})
+	opts = append(opts, webrtc.WithInterceptorRegistry(registry))
+	api = webrtc.NewAPI(opts...)
+	t.pc, err = api.NewPeerConnection(rtc.config.WebRTC.ICE.configuration(rtc.config.WebRTC.Configuration))
 
 	if err != nil {
 		log.Errorf("NewPeerConnection error: %v", err)
@@ -77,6 +123,83 @@ func NewTransport(role Target, rtc *RTC) *Transport {
 	return t
 }
 
+// gatheredDescription wait ICE gathering up to GatherTimeout, return the local sdp with candidates gathered,
+// or sdp if no wait
+func (t *Transport) gatheredDescription(sdp webrtc.SessionDescription) webrtc.SessionDescription {
+	timeout := t.rtc.config.WebRTC.GatherTimeout
+	if timeout <= 0 {
+		return sdp
+	}
+	select {
+	case <-webrtc.GatheringCompletePromise(t.pc):
+	case <-time.After(timeout):
+		log.Warnf("gather candidate timeout %v, continue with candidates gathered", timeout)
+	}
+	if desc := t.pc.LocalDescription(); desc != nil {
+		return *desc
+	}
+	return sdp
+}
+
 func (t *Transport) GetPeerConnection() *webrtc.PeerConnection {
 	return t.pc
 }
+
+// AddLocalTrackRTP add a custom rtp track, then push rtp to it by WriteRTP
+func (t *Transport) AddLocalTrackRTP(track *webrtc.TrackLocalStaticRTP) (*webrtc.RTPSender, error) {
+	if err := t.rtc.canPublish(); err != nil {
+		return nil, err
+	}
+	if t.role == Target_PUBLISHER {
+		if err := t.rtc.checkTrackCodec(track); err != nil {
+			return nil, err
+		}
+	}
+	sender, err := t.pc.AddTrack(track)
+	if err != nil {
+		log.Errorf("AddTrack error: %v", err)
+		return nil, err
+	}
+	t.Lock()
+	t.localTracks[track.ID()] = track
+	t.Unlock()
+	if t.role == Target_PUBLISHER {
+		t.rtc.trackPublished(sender)
+	}
+
+	// read rtcp, so interceptors like nack work
+	go func() {
+		b := make([]byte, 1500)
+		for {
+			if _, _, err := sender.Read(b); err != nil {
+				return
+			}
+		}
+	}()
+
+	if t.role == Target_PUBLISHER {
+		t.rtc.onNegotiationNeeded()
+	}
+	return sender, nil
+}
+
+// WriteRTP write a rtp packet to the track added by AddLocalTrackRTP
+func (t *Transport) WriteRTP(trackID string, pkt *rtp.Packet) error {
+	t.Lock()
+	track, ok := t.localTracks[trackID]
+	t.Unlock()
+	if !ok {
+		return errInvalidTrack
+	}
+	return track.WriteRTP(pkt)
+}
+
+// registryFactory use a registry as a factory, to chain it in another registry
+type registryFactory struct {
+	registry *interceptor.Registry
+}
+
+// NewInterceptor implements interceptor.Factory
+func (f *registryFactory) NewInterceptor(id string) (interceptor.Interceptor, error) {
+	return f.registry.Build(id)
+}
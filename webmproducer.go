@@ -3,9 +3,11 @@ package engine
 import (
 	"errors"
 	"fmt"
-	"math"
+	"io"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ebml-go/webm"
@@ -19,19 +21,54 @@ type trackInfo struct {
 	rate  int
 }
 
+// webmSource is a parsed webm file to swap in
+type webmSource struct {
+	reader   *webm.Reader
+	webm     webm.WebM
+	file     io.Closer
+	trackMap map[uint]*trackInfo
+}
+
 // WebMProducer support streaming by webm which encode with vp8 and opus
 type WebMProducer struct {
+	// estimated sending bitrate(bps), first for 64-bit atomic alignment
+	estimatedBitrate uint64
+	// timecode of the last sent packet and duration of the file
+	position int64
+	duration int64
+	// 1 if a keyframe is requested, video delta frames are skipped until the next keyframe, atomic
+	keyframeWanted int32
+
 	name          string
 	stop          bool
+	loop          bool
 	paused        bool
 	pauseChan     chan bool
 	seekChan      chan time.Duration
+	swapChan      chan *webmSource
 	offsetSeconds int
 	reader        *webm.Reader
 	webm          webm.WebM
 	trackMap      map[uint]*trackInfo
-	file          *os.File
-	sendByte      int
+	// the opened file, nil if from a reader owned by the caller
+	file     io.Closer
+	sendByte int
+
+	// drop video delta frames when sending over the estimated bandwidth
+	dropOnCongestion bool
+	// transport-cc estimate of the pub transport, set when published by a client
+	estimator func() uint64
+	// guard loop, dropOnCongestion and the callbacks, which may be changed while playing
+	lock sync.Mutex
+	// OnEnded fired when the file ends if not looping, the tracks keep alive and Seek/SwapFile play again,
+	// set before Start or by SetOnEnded
+	OnEnded func()
+	// OnDropRate report the ratio of dropped video frames every second when dropOnCongestion, set before Start
+	// or by SetOnDropRate
+	OnDropRate func(rate float64)
+	// OnError fired when playback fails(e.g. writing a sample), the producer is stopped and the tracks stay published,
+	// set before Start or by SetOnError
+	OnError func(err error)
 }
 
 // NewWebMProducer new a WebMProducer
@@ -48,18 +85,36 @@ func NewWebMProducer(name string, offset int) *WebMProducer {
 		return nil
 	}
 
-	p := &WebMProducer{
+	p := newWebMProducer(name, offset, reader, w)
+	p.file = r
+	return p
+}
+
+// NewWebMProducerFromReader new a WebMProducer reading webm from r, e.g. streamed from object storage
+// or generated in memory without a temp file, r is not closed by the producer
+func NewWebMProducerFromReader(r io.ReadSeeker, offset int) *WebMProducer {
+	var w webm.WebM
+	reader, err := webm.Parse(r, &w)
+	if err != nil {
+		log.Errorf("error: %v", err)
+		return nil
+	}
+	return newWebMProducer(fmt.Sprintf("reader_%p", r), offset, reader, w)
+}
+
+func newWebMProducer(name string, offset int, reader *webm.Reader, w webm.WebM) *WebMProducer {
+	return &WebMProducer{
 		name:          name,
 		offsetSeconds: offset,
+		loop:          true,
 		reader:        reader,
 		webm:          w,
 		trackMap:      make(map[uint]*trackInfo),
-		file:          r,
 		pauseChan:     make(chan bool),
 		seekChan:      make(chan time.Duration, 1),
+		swapChan:      make(chan *webmSource, 1),
+		duration:      int64(w.GetDuration()),
 	}
-
-	return p
 }
 
 func (t *WebMProducer) Stop() {
@@ -67,6 +122,55 @@ func (t *WebMProducer) Stop() {
 	t.reader.Shutdown()
 }
 
+// release a producer never started, e.g. its tracks failed to publish
+func (t *WebMProducer) release() {
+	t.Stop()
+	go drainReader(t.reader)
+	if t.file != nil {
+		t.file.Close()
+	}
+}
+
+// fail stop the producer on a runtime error and fire OnError
+func (t *WebMProducer) fail(err error) {
+	log.Errorf("webm producer %v err=%v", t.name, err)
+	t.Stop()
+	t.lock.Lock()
+	onError := t.OnError
+	t.lock.Unlock()
+	if onError != nil {
+		onError(err)
+	}
+}
+
+// SetLoop restart the file when it ends, default true
+func (t *WebMProducer) SetLoop(loop bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.loop = loop
+}
+
+// SetOnEnded set OnEnded
+func (t *WebMProducer) SetOnEnded(f func()) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.OnEnded = f
+}
+
+// SetOnError set OnError
+func (t *WebMProducer) SetOnError(f func(err error)) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.OnError = f
+}
+
+// ending get loop and OnEnded
+func (t *WebMProducer) ending() (bool, func()) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.loop, t.OnEnded
+}
+
 func (t *WebMProducer) Start() {
 	go t.readLoop()
 }
@@ -76,10 +180,191 @@ func (t *WebMProducer) SeekP(ts int) {
 	t.seekChan <- seekDuration
 }
 
+// Seek seek to the nearest keyframe before pos
+func (t *WebMProducer) Seek(pos time.Duration) error {
+	if _, duration := t.Position(); pos < 0 || (duration > 0 && pos > duration) {
+		return errInvalidParams
+	}
+	t.seekChan <- pos
+	return nil
+}
+
+// isSeekKeyframe check if pck is a keyframe to resume from when seeking,
+// keyframes of video if the file has video, or of any track(all audio blocks are keyframes)
+func (t *WebMProducer) isSeekKeyframe(pck webm.Packet) bool {
+	if !pck.Keyframe {
+		return false
+	}
+	track, ok := t.trackMap[pck.TrackNumber]
+	if !ok {
+		return false
+	}
+	if track.track.Kind() == webrtc.RTPCodecTypeVideo {
+		return true
+	}
+	for _, info := range t.trackMap {
+		if info.track.Kind() == webrtc.RTPCodecTypeVideo {
+			return false
+		}
+	}
+	return true
+}
+
+// Position return the playback position and the duration of the file
+func (t *WebMProducer) Position() (pos, duration time.Duration) {
+	return time.Duration(atomic.LoadInt64(&t.position)), time.Duration(atomic.LoadInt64(&t.duration))
+}
+
 func (t *WebMProducer) Pause(pause bool) {
 	t.pauseChan <- pause
 }
 
+// SwapFile replace the source with another webm file, keep the same local tracks,
+// so timestamps continue and subscribers see a seamless content change
+func (t *WebMProducer) SwapFile(name string) error {
+	r, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	var w webm.WebM
+	reader, err := webm.Parse(r, &w)
+	if err != nil {
+		r.Close()
+		return err
+	}
+
+	trackMap := make(map[uint]*trackInfo)
+	for _, info := range t.trackMap {
+		switch info.track.Kind() {
+		case webrtc.RTPCodecTypeVideo:
+			vTrack := w.FindFirstVideoTrack()
+			if vTrack == nil || webmVideoMime(vTrack.CodecID) != info.track.Codec().MimeType {
+				err = fmt.Errorf("%w: %v video codec not match %v", errInvalidFile, name, info.track.Codec().MimeType)
+				break
+			}
+			trackMap[vTrack.TrackNumber] = &trackInfo{track: info.track, rate: info.rate}
+		case webrtc.RTPCodecTypeAudio:
+			aTrack := w.FindFirstAudioTrack()
+			if aTrack == nil {
+				err = fmt.Errorf("%w: %v not audio track", errInvalidFile, name)
+				break
+			}
+			trackMap[aTrack.TrackNumber] = &trackInfo{track: info.track, rate: int(aTrack.Audio.OutputSamplingFrequency)}
+		}
+		if err != nil {
+			reader.Shutdown()
+			go drainReader(reader)
+			r.Close()
+			return err
+		}
+	}
+
+	t.swapChan <- &webmSource{reader: reader, webm: w, file: r, trackMap: trackMap}
+	return nil
+}
+
+// swapSource replace the source in readLoop, the old reader is shutdown
+func (t *WebMProducer) swapSource(src *webmSource) {
+	log.Infof("Swap file")
+	old, oldFile := t.reader, t.file
+	t.reader, t.webm, t.file, t.trackMap = src.reader, src.webm, src.file, src.trackMap
+	atomic.StoreInt64(&t.duration, int64(t.webm.GetDuration()))
+	atomic.StoreInt64(&t.position, 0)
+	old.Shutdown()
+	go func() {
+		drainReader(old)
+		if oldFile != nil {
+			oldFile.Close()
+		}
+	}()
+}
+
+// webmCodecs get the video mime(empty if no supported video) and if there is audio of a webm file
+func webmCodecs(name string) (string, bool, error) {
+	r, err := os.Open(name)
+	if err != nil {
+		return "", false, err
+	}
+	defer r.Close()
+	var w webm.WebM
+	reader, err := webm.Parse(r, &w)
+	if err != nil {
+		return "", false, err
+	}
+	reader.Shutdown()
+	go drainReader(reader)
+
+	var mime string
+	if vTrack := w.FindFirstVideoTrack(); vTrack != nil {
+		mime = webmVideoMime(vTrack.CodecID)
+	}
+	return mime, w.FindFirstAudioTrack() != nil, nil
+}
+
+// drainReader consume a shutdown reader, so its goroutine exits
+func drainReader(reader *webm.Reader) {
+	for range reader.Chan {
+	}
+}
+
+// webmVideoMime get the mime of a webm video codec id, empty if not supported
+func webmVideoMime(codecID string) string {
+	switch codecID {
+	case "V_VP8":
+		return webrtc.MimeTypeVP8
+	case "V_VP9":
+		return webrtc.MimeTypeVP9
+	}
+	return ""
+}
+
+// HasVideo check if the file has a video track, the metadata methods are usable before Start
+func (t *WebMProducer) HasVideo() bool {
+	return t.webm.FindFirstVideoTrack() != nil
+}
+
+// HasAudio check if the file has an audio track
+func (t *WebMProducer) HasAudio() bool {
+	return t.webm.FindFirstAudioTrack() != nil
+}
+
+// VideoCodec get the mime of the video, empty if no video or the codec is not supported(see VideoCodecID)
+func (t *WebMProducer) VideoCodec() string {
+	if vTrack := t.webm.FindFirstVideoTrack(); vTrack != nil {
+		return webmVideoMime(vTrack.CodecID)
+	}
+	return ""
+}
+
+// VideoCodecID get the webm codec id of the video(e.g. V_VP8), empty if no video
+func (t *WebMProducer) VideoCodecID() string {
+	if vTrack := t.webm.FindFirstVideoTrack(); vTrack != nil {
+		return vTrack.CodecID
+	}
+	return ""
+}
+
+// AudioCodec get the mime of the audio, empty if no audio or the codec is not supported(only opus)
+func (t *WebMProducer) AudioCodec() string {
+	if aTrack := t.webm.FindFirstAudioTrack(); aTrack != nil && aTrack.CodecID == "A_OPUS" {
+		return webrtc.MimeTypeOpus
+	}
+	return ""
+}
+
+// VideoSize get the resolution of the video, 0 if no video
+func (t *WebMProducer) VideoSize() (width, height uint) {
+	if vTrack := t.webm.FindFirstVideoTrack(); vTrack != nil {
+		return vTrack.Video.PixelWidth, vTrack.Video.PixelHeight
+	}
+	return 0, 0
+}
+
+// Duration get the duration of the file
+func (t *WebMProducer) Duration() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.duration))
+}
+
 // GetVideoTrack get video track
 func (t *WebMProducer) GetVideoTrack() (*webrtc.TrackLocalStaticSample, error) {
 	var err error
@@ -89,15 +374,12 @@ func (t *WebMProducer) GetVideoTrack() (*webrtc.TrackLocalStaticSample, error) {
 	if vTrack == nil {
 		return nil, errors.New("not video track")
 	}
-	switch vTrack.CodecID {
-	case "V_VP8":
-		track, err = webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}, "video", streamId)
-	case "V_VP9":
-		track, err = webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9, ClockRate: 90000}, "video", streamId)
-	default:
+	mime := webmVideoMime(vTrack.CodecID)
+	if mime == "" {
 		log.Errorf("Unsupported video codec %v", vTrack.CodecID)
-		return nil, err
+		return nil, fmt.Errorf("%w: unsupported video codec %v", errInvalidFile, vTrack.CodecID)
 	}
+	track, err = webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: mime, ClockRate: 90000}, "video", streamId)
 	t.trackMap[vTrack.TrackNumber] = &trackInfo{track: track, rate: 90000}
 	return track, err
 }
@@ -117,11 +399,85 @@ func (t *WebMProducer) GetAudioTrack() (*webrtc.TrackLocalStaticSample, error) {
 	return track, err
 }
 
+// EnableDropOnCongestion drop video delta frames to stay under the send bitrate estimated by transport-cc
+// feedback of the pub transport(see EstimatedSendBitrate), rather than queueing and building latency,
+// sending resumes from the next keyframe. Nothing is dropped until the first feedback
+func (t *WebMProducer) EnableDropOnCongestion(enable bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.dropOnCongestion = enable
+}
+
+// SetOnDropRate set OnDropRate
+func (t *WebMProducer) SetOnDropRate(f func(rate float64)) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.OnDropRate = f
+}
+
+// requestKeyframe skip video delta frames until the next keyframe of the file, e.g. for a PLI,
+// it returns at once, the file is not rewound
+func (t *WebMProducer) requestKeyframe() {
+	atomic.StoreInt32(&t.keyframeWanted, 1)
+}
+
+// dropping get dropOnCongestion and OnDropRate
+func (t *WebMProducer) dropping() (bool, func(rate float64)) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.dropOnCongestion, t.OnDropRate
+}
+
+// SetEstimatedBitrate set the estimated sending bandwidth in bps for a producer not published by a client,
+// 0 means unknown. Published producers follow the transport-cc estimate of the client
+func (t *WebMProducer) SetEstimatedBitrate(bitrate uint64) {
+	atomic.StoreUint64(&t.estimatedBitrate, bitrate)
+}
+
+// estimated get the estimated sending bandwidth in bps, 0 means unknown
+func (t *WebMProducer) estimated() uint64 {
+	if t.estimator != nil {
+		return t.estimator()
+	}
+	return atomic.LoadUint64(&t.estimatedBitrate)
+}
+
 func (t *WebMProducer) readLoop() {
 	startTime := time.Now()
 	timeEps := 5 * time.Millisecond
 
+	// congestion drop state of video
+	var (
+		windowStart   = time.Now()
+		windowBytes   uint64
+		windowSent    int
+		windowDropped int
+		waitKeyframe  bool
+		dropped       uint16
+	)
+	shouldDrop := func(pck webm.Packet) bool {
+		if time.Since(windowStart) >= time.Second {
+			if _, onDropRate := t.dropping(); onDropRate != nil && windowSent+windowDropped > 0 {
+				onDropRate(float64(windowDropped) / float64(windowSent+windowDropped))
+			}
+			windowStart, windowBytes, windowSent, windowDropped = time.Now(), 0, 0, 0
+		}
+		// always send keyframes, or the stream never recovers
+		if pck.Keyframe {
+			waitKeyframe = false
+			return false
+		}
+		estimated := t.estimated()
+		if !waitKeyframe && (estimated == 0 || (windowBytes+uint64(len(pck.Data)))*8 <= estimated) {
+			return false
+		}
+		// delta frames after a dropped one can't be decoded
+		waitKeyframe = true
+		return true
+	}
+
 	seekDuration := time.Duration(-1)
+	seekReached := false
 
 	if t.offsetSeconds > 0 {
 		t.SeekP(t.offsetSeconds)
@@ -130,9 +486,16 @@ func (t *WebMProducer) readLoop() {
 	startSeek := func(seekTime time.Duration) {
 		t.reader.Seek(seekTime)
 		seekDuration = seekTime
+		seekReached = false
 	}
 
-	for pck := range t.reader.Chan {
+	for {
+		// reader may be swapped
+		pck, ok := <-t.reader.Chan
+		if !ok {
+			break
+		}
+
 		if t.paused {
 			log.Infof("Paused")
 			// Wait for unpause
@@ -148,10 +511,29 @@ func (t *WebMProducer) readLoop() {
 
 		// Restart when track runs out
 		if pck.Timecode < 0 {
-			if !t.stop {
+			loop, onEnded := t.ending()
+			if !t.stop && loop {
 				log.Infof("Restart media")
 				startSeek(0)
 			}
+			if !t.stop && !loop {
+				log.Infof("Media ended")
+				if onEnded != nil {
+					onEnded()
+				}
+				// wait for seek or swap to play again
+				select {
+				case dur := <-t.seekChan:
+					startSeek(dur)
+				case src := <-t.swapChan:
+					t.swapSource(src)
+					startTime = time.Now()
+					seekDuration = time.Duration(-1)
+				case pause := <-t.pauseChan:
+					t.paused = pause
+				case <-t.reader.Chan:
+				}
+			}
 			continue
 		}
 
@@ -161,6 +543,11 @@ func (t *WebMProducer) readLoop() {
 			log.Infof("Seek duration=%v", dur)
 			startSeek(dur)
 			continue
+		case src := <-t.swapChan:
+			t.swapSource(src)
+			startTime = time.Now()
+			seekDuration = time.Duration(-1)
+			continue
 		case pause := <-t.pauseChan:
 			t.paused = pause
 			if pause {
@@ -169,11 +556,17 @@ func (t *WebMProducer) readLoop() {
 		default:
 		}
 
-		// Handle actual seek
-		if seekDuration > -1 && math.Abs(float64((pck.Timecode-seekDuration).Milliseconds())) < 30.0 {
-			startTime = time.Now().Add(-seekDuration)
+		// Handle actual seek, skip packets until the nearest keyframe before seekDuration,
+		// then resume from it, timestamps of the tracks continue
+		if seekDuration > -1 {
+			// the file may not have a keyframe right at seekDuration(e.g. 0)
+			seekReached = seekReached || pck.Timecode <= seekDuration
+			if !t.isSeekKeyframe(pck) || (pck.Timecode > seekDuration && !seekReached) {
+				continue
+			}
+			log.Infof("Seek to keyframe timecode=%v", pck.Timecode)
+			startTime = time.Now().Add(-pck.Timecode)
 			seekDuration = time.Duration(-1)
-			continue
 		}
 
 		// Find sender
@@ -184,13 +577,40 @@ func (t *WebMProducer) readLoop() {
 				time.Sleep(timeDiff - time.Millisecond)
 			}
 
+			sample := media.Sample{Data: pck.Data, Duration: time.Millisecond * 20}
+			if track.track.Kind() == webrtc.RTPCodecTypeVideo {
+				// a keyframe is requested, the delta frames before it can't be decoded
+				if pck.Keyframe {
+					atomic.StoreInt32(&t.keyframeWanted, 0)
+				} else if atomic.LoadInt32(&t.keyframeWanted) == 1 {
+					dropped++
+					continue
+				}
+				if drop, _ := t.dropping(); drop {
+					if shouldDrop(pck) {
+						log.Tracef("t=%v drop video frame len=%v", t, len(pck.Data))
+						windowDropped++
+						dropped++
+						continue
+					}
+					windowSent++
+					windowBytes += uint64(len(pck.Data))
+				}
+				// keep timestamps advancing over dropped frames
+				sample.PrevDroppedPackets = dropped
+				dropped = 0
+			}
+
 			// Send samples
-			if ivfErr := track.track.WriteSample(media.Sample{Data: pck.Data, Duration: time.Millisecond * 20}); ivfErr != nil {
-				log.Errorf("Track write error=%v", ivfErr)
-			} else {
-				log.Tracef("t=%v mime=%v kind=%v streamid=%v len=%v", t, track.track.Codec().MimeType, track.track.Kind(), track.track.StreamID(), len(pck.Data))
-				t.sendByte += len(pck.Data)
+			if ivfErr := track.track.WriteSample(sample); ivfErr != nil {
+				// don't keep a dead track sending nothing
+				t.fail(ivfErr)
+				go drainReader(t.reader)
+				break
 			}
+			log.Tracef("t=%v mime=%v kind=%v streamid=%v len=%v", t, track.track.Codec().MimeType, track.track.Kind(), track.track.StreamID(), len(pck.Data))
+			t.sendByte += len(pck.Data)
+			atomic.StoreInt64(&t.position, int64(pck.Timecode))
 		}
 	}
 	log.Infof("Exiting webm producer")
@@ -0,0 +1,277 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/pion/ion-log"
+	"github.com/pion/ion/proto/rtc"
+	"github.com/pion/webrtc/v3"
+)
+
+// httpTimeout of WHIP/WHEP requests
+const httpTimeout = 10 * time.Second
+
+var httpClient = &http.Client{Timeout: httpTimeout}
+
+// postSDP post an offer to a WHIP/WHEP endpoint, return the answer and the session resource url
+func postSDP(endpoint, token, offer string) (string, string, error) {
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(offer))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/sdp")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("%w: POST %v status=%v body=%s", errHTTPSignal, endpoint, resp.Status, body)
+	}
+
+	// Location may be relative to the endpoint
+	resource := resp.Header.Get("Location")
+	if resource != "" {
+		base, err := url.Parse(endpoint)
+		if err != nil {
+			return "", "", err
+		}
+		ref, err := url.Parse(resource)
+		if err != nil {
+			return "", "", err
+		}
+		resource = base.ResolveReference(ref).String()
+	}
+	return string(body), resource, nil
+}
+
+// deleteResource delete a WHIP/WHEP session resource to leave
+func deleteResource(resource, token string) error {
+	if resource == "" {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodDelete, resource, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("%w: DELETE %v status=%v", errHTTPSignal, resource, resp.Status)
+	}
+	return nil
+}
+
+// WHIPSignaller is a Signaller publishing by WHIP(HTTP POST offer, get answer, DELETE to leave)
+// instead of the ion signaling, use it with NewRTCWithSignaller. WHIP has no trickle and renegotiation here,
+// so the join offer waits ICE gathering(up to WebRTCTransportConfig.GatherTimeout, 10s if 0) to carry
+// the candidates, publish all tracks before Join.
+type WHIPSignaller struct {
+	endpoint string
+	token    string
+	resource string
+	// bound by NewRTCWithSignaller, for the candidates of the join offer
+	rtc *RTC
+
+	replies chan *rtc.Reply
+	closed  chan struct{}
+	once    sync.Once
+	sync.Mutex
+}
+
+// NewWHIPSignaller create a WHIP signaller, token is sent as Bearer if not empty
+func NewWHIPSignaller(endpoint, token string) *WHIPSignaller {
+	return &WHIPSignaller{
+		endpoint: endpoint,
+		token:    token,
+		replies:  make(chan *rtc.Reply, 1),
+		closed:   make(chan struct{}),
+	}
+}
+
+// bind implements clientBinder
+func (s *WHIPSignaller) bind(r *RTC) {
+	s.rtc = r
+}
+
+// gathered wait ICE gathering of the pub transport, return the offer with the candidates
+func (s *WHIPSignaller) gathered(offer string) string {
+	if s.rtc == nil {
+		return offer
+	}
+	pub := s.rtc.GetPubTransport()
+	if pub == nil {
+		return offer
+	}
+	pc := pub.pc
+	timeout := s.rtc.config.WebRTC.GatherTimeout
+	if timeout <= 0 {
+		timeout = httpTimeout
+	}
+	select {
+	case <-webrtc.GatheringCompletePromise(pc):
+	case <-time.After(timeout):
+		log.Warnf("WHIP gather candidate timeout %v, continue with candidates gathered", timeout)
+	}
+	if desc := pc.LocalDescription(); desc != nil {
+		return s.rtc.pubSDP(desc.SDP)
+	}
+	return offer
+}
+
+// Send post the join offer with the candidates gathered, trickle(the candidates are in the offer)
+// and subscription are ignored
+func (s *WHIPSignaller) Send(request *rtc.Request) error {
+	switch payload := request.Payload.(type) {
+	case *rtc.Request_Join:
+		answer, resource, err := postSDP(s.endpoint, s.token, s.gathered(payload.Join.Description.Sdp))
+		if err != nil {
+			log.Errorf("WHIP join err=%v", err)
+			return err
+		}
+		s.Lock()
+		s.resource = resource
+		s.Unlock()
+		s.replies <- &rtc.Reply{
+			Payload: &rtc.Reply_Join{
+				Join: &rtc.JoinReply{
+					Success: true,
+					Description: &rtc.SessionDescription{
+						Target: rtc.Target_PUBLISHER,
+						Type:   "answer",
+						Sdp:    answer,
+					},
+				},
+			},
+		}
+	case *rtc.Request_Description:
+		return errWHIPRenegotiation
+	default:
+		log.Debugf("WHIP ignore request=%v", request)
+	}
+	return nil
+}
+
+// Recv return the replies to Send until CloseSend
+func (s *WHIPSignaller) Recv() (*rtc.Reply, error) {
+	select {
+	case reply := <-s.replies:
+		return reply, nil
+	case <-s.closed:
+		return nil, io.EOF
+	}
+}
+
+// CloseSend delete the WHIP session
+func (s *WHIPSignaller) CloseSend() error {
+	var err error
+	s.once.Do(func() {
+		close(s.closed)
+		s.Lock()
+		resource := s.resource
+		s.Unlock()
+		err = deleteResource(resource, s.token)
+	})
+	return err
+}
+
+// WHEPClient subscribe a stream by WHEP, independent of RTC
+type WHEPClient struct {
+	pc       *webrtc.PeerConnection
+	config   WebRTCTransportConfig
+	token    string
+	resource string
+
+	OnTrack func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver)
+}
+
+// NewWHEPClient create a WHEP client receiving one video and one audio track
+func NewWHEPClient(config WebRTCTransportConfig) (*WHEPClient, error) {
+	me, err := getSubscriberMediaEngine()
+	if err != nil {
+		return nil, err
+	}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(me), webrtc.WithSettingEngine(config.Setting))
+	pc, err := api.NewPeerConnection(config.ICE.configuration(config.Configuration))
+	if err != nil {
+		return nil, err
+	}
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+		if _, err := pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+			pc.Close()
+			return nil, err
+		}
+	}
+
+	w := &WHEPClient{pc: pc, config: config}
+	pc.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		log.Infof("WHEP got track id=%v kind=%v", track.ID(), track.Kind())
+		if w.OnTrack != nil {
+			w.OnTrack(track, receiver)
+		}
+	})
+	return w, nil
+}
+
+// Subscribe post the offer to a WHEP endpoint and set the answer
+func (w *WHEPClient) Subscribe(endpoint, token string) error {
+	offer, err := w.pc.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	gathered := webrtc.GatheringCompletePromise(w.pc)
+	if err = w.pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	// no trickle, wait the candidates
+	timeout := w.config.GatherTimeout
+	if timeout <= 0 {
+		timeout = httpTimeout
+	}
+	select {
+	case <-gathered:
+	case <-time.After(timeout):
+		log.Warnf("WHEP gather candidate timeout %v, continue with candidates gathered", timeout)
+	}
+
+	answer, resource, err := postSDP(endpoint, token, w.pc.LocalDescription().SDP)
+	if err != nil {
+		return err
+	}
+	w.token, w.resource = token, resource
+	return w.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer})
+}
+
+// GetPeerConnection get the receiving pc, e.g. for stats
+func (w *WHEPClient) GetPeerConnection() *webrtc.PeerConnection {
+	return w.pc
+}
+
+// Close delete the WHEP session and close the pc
+func (w *WHEPClient) Close() error {
+	err := deleteResource(w.resource, w.token)
+	if closeErr := w.pc.Close(); closeErr != nil {
+		err = closeErr
+	}
+	return err
+}